@@ -0,0 +1,335 @@
+package analytics
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/modood/aip/db"
+
+	"github.com/pkg/errors"
+)
+
+// year 一年的时长，用于年化
+const year = 365 * 24 * time.Hour
+
+// ErrNoSolution 现金流没有内部收益率，如只有投入没有取回
+var ErrNoSolution = errors.New("cash flows have no internal rate of return")
+
+// Point 某一时刻的持仓及净值，通常来自统计表
+type Point struct {
+	Time       time.Time // 时间
+	Position   float64   // 持仓总额（基础货币）
+	Investment float64   // 累计投入（报价货币）
+	Price      float64   // 价格
+	Equity     float64   // 净值（报价货币）
+}
+
+// Flow 现金流，投入为负，取回为正
+type Flow struct {
+	Time   time.Time // 时间
+	Amount float64   // 金额（报价货币）
+}
+
+// Price 某一时刻的价格
+type Price struct {
+	Time  time.Time // 时间
+	Price float64   // 价格
+}
+
+// Build 按时间顺序重放订单，返回各价格时刻之前的订单形成的持仓及净值，
+// 以及 [第一个价格时刻, 最后一个价格时刻) 内订单的现金流。
+// 买单的花费为投入，卖单扣除手续费后的所得为取回，卖单的成交金额按记录为负数；
+// base 为基础货币，用于判断手续费币种，
+// 旧订单没有手续费币种，按火币规则买单以基础货币、卖单以报价货币收取
+func Build(orders []*db.Order, base string, prices []Price) ([]Point, []Flow) {
+	if len(prices) == 0 {
+		return nil, nil
+	}
+
+	sorted := make([]*db.Order, len(orders))
+	copy(sorted, orders)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Created < sorted[j].Created })
+
+	var (
+		points     = make([]Point, 0, len(prices))
+		flows      []Flow
+		position   float64
+		investment float64
+		next       int
+		first      = prices[0].Time
+		last       = prices[len(prices)-1].Time
+	)
+	for _, p := range prices {
+		for ; next < len(sorted) && time.Unix(int64(sorted[next].Created), 0).Before(p.Time); next++ {
+			o := sorted[next]
+			if o.BaseAmount == 0 {
+				continue
+			}
+
+			sell := o.Type == "sell-market" || o.Type == "sell-limit"
+			baseFee := o.FeeCurrency == base || (o.FeeCurrency == "" && !sell)
+			amount, cash := o.BaseAmount, o.QuoteAmount
+			if baseFee {
+				amount -= o.Fees
+			} else {
+				cash += o.Fees
+			}
+
+			position += amount
+			investment += cash
+			if t := time.Unix(int64(o.Created), 0); !t.Before(first) && t.Before(last) {
+				flows = append(flows, Flow{Time: t, Amount: -cash})
+			}
+		}
+
+		points = append(points, Point{
+			Time:       p.Time,
+			Position:   position,
+			Investment: investment,
+			Price:      p.Price,
+			Equity:     position * p.Price,
+		})
+	}
+
+	return points, flows
+}
+
+// Summary 区间收益指标，比率均为小数，如 0.1 表示 10%
+type Summary struct {
+	Start       time.Time // 区间开始
+	End         time.Time // 区间结束
+	Contributed float64   // 区间内净投入（报价货币）
+	Equity      float64   // 区间结束时的净值
+	Gain        float64   // 区间盈亏：期末净值 - 期初净值 - 净投入
+	ROI         float64   // 简单收益率：盈亏 / (期初净值 + 投入)
+	XIRR        float64   // 资金加权收益率（年化）
+	TWR         float64   // 时间加权收益率（区间，不年化）
+	MaxDrawdown float64   // 最大回撤，按时间加权的净值计算，为负数或 0
+	Volatility  float64   // 波动率（年化）
+	AverageCost float64   // 期末持仓的平均成本
+}
+
+// Analyze 计算区间收益指标。
+// 参数 points 按时间排序的净值，第一个点为区间开始时的净值（开始时没有持仓则净值为 0），
+// 最后一个点为区间结束时的净值；flows 为区间内的现金流
+func Analyze(points []Point, flows []Flow) *Summary {
+	if len(points) == 0 {
+		return &Summary{}
+	}
+
+	first, last := points[0], points[len(points)-1]
+	s := &Summary{
+		Start:  first.Time,
+		End:    last.Time,
+		Equity: last.Equity,
+	}
+
+	var in float64
+	for _, f := range flows {
+		s.Contributed -= f.Amount
+		if f.Amount < 0 {
+			in -= f.Amount
+		}
+	}
+	s.Gain = last.Equity - first.Equity - s.Contributed
+	s.ROI = ROI(s.Gain, first.Equity+in)
+	if last.Position > 0 {
+		s.AverageCost = last.Investment / last.Position
+	}
+
+	// 期初净值视为投入，期末净值视为取回
+	cash := make([]Flow, 0, len(flows)+2)
+	if first.Equity != 0 {
+		cash = append(cash, Flow{Time: first.Time, Amount: -first.Equity})
+	}
+	cash = append(cash, flows...)
+	cash = append(cash, Flow{Time: last.Time, Amount: last.Equity})
+
+	// 没有解时（如区间内没有持仓）为 0
+	s.XIRR, _ = XIRR(cash)
+
+	returns := Returns(points)
+	s.TWR = TWR(returns)
+	s.MaxDrawdown = MaxDrawdown(returns)
+	s.Volatility = Volatility(returns, last.Time.Sub(first.Time))
+
+	return s
+}
+
+// Benchmark 定投与同样的资金在区间开始时一次性买入并持有（lump sum），或持有报价货币不买入的对比
+type Benchmark struct {
+	Invested  float64 // 投入总额：期初净值 + 区间内的投入
+	DCA       float64 // 定投在区间结束时的净值，加上卖出取回的资金
+	LumpSum   float64 // 一次性买入在区间结束时的净值
+	Quote     float64 // 持有报价货币的净值，即投入总额
+	Advantage float64 // 定投相对一次性买入的超额收益率：(定投 - 一次性买入) / 投入总额
+}
+
+// Compare 计算区间的对比基准，一次性买入以 open 价格买入，以 close 价格估值，不计手续费。
+// 参数 points 及 flows 与 Analyze 相同
+func Compare(points []Point, flows []Flow, open, close float64) *Benchmark {
+	b := &Benchmark{}
+	if len(points) == 0 {
+		return b
+	}
+
+	b.Invested = points[0].Equity
+	b.DCA = points[len(points)-1].Equity
+	for _, f := range flows {
+		if f.Amount < 0 {
+			b.Invested -= f.Amount
+		} else {
+			b.DCA += f.Amount
+		}
+	}
+
+	b.Quote = b.Invested
+	if open > 0 {
+		b.LumpSum = b.Invested / open * close
+	}
+	b.Advantage = ROI(b.DCA-b.LumpSum, b.Invested)
+
+	return b
+}
+
+// ROI 简单收益率，投入为 0 时返回 0
+func ROI(gain, invested float64) float64 {
+	if invested == 0 {
+		return 0
+	}
+	return gain / invested
+}
+
+// Returns 返回相邻两点之间扣除投入变化后的收益率，前一点净值为 0 的区间跳过。
+// 区间内的投入视为在区间结束时发生
+func Returns(points []Point) []float64 {
+	var r []float64
+	for i := 1; i < len(points); i++ {
+		prev, cur := points[i-1], points[i]
+		if prev.Equity <= 0 {
+			continue
+		}
+		r = append(r, (cur.Equity-(cur.Investment-prev.Investment))/prev.Equity-1)
+	}
+	return r
+}
+
+// TWR 时间加权收益率，各区间收益率连乘
+func TWR(returns []float64) float64 {
+	growth := 1.0
+	for _, r := range returns {
+		growth *= 1 + r
+	}
+	return growth - 1
+}
+
+// MaxDrawdown 按收益率连乘得到的净值计算最大回撤
+func MaxDrawdown(returns []float64) float64 {
+	var (
+		growth = 1.0
+		peak   = 1.0
+		dd     float64
+	)
+	for _, r := range returns {
+		growth *= 1 + r
+		if growth > peak {
+			peak = growth
+		}
+		if d := growth/peak - 1; d < dd {
+			dd = d
+		}
+	}
+	return dd
+}
+
+// Drawdowns 返回每个点按时间加权的净值相对此前最高点的回撤，为负数或 0
+func Drawdowns(points []Point) []float64 {
+	var (
+		dd     = make([]float64, len(points))
+		growth = 1.0
+		peak   = 1.0
+	)
+	for i := 1; i < len(points); i++ {
+		prev, cur := points[i-1], points[i]
+		if prev.Equity > 0 {
+			growth *= (cur.Equity - (cur.Investment - prev.Investment)) / prev.Equity
+		}
+		if growth > peak {
+			peak = growth
+		}
+		dd[i] = growth/peak - 1
+	}
+	return dd
+}
+
+// Volatility 收益率的样本标准差，按区间的平均时长年化
+func Volatility(returns []float64, span time.Duration) float64 {
+	n := len(returns)
+	if n < 2 || span <= 0 {
+		return 0
+	}
+
+	var mean float64
+	for _, r := range returns {
+		mean += r
+	}
+	mean /= float64(n)
+
+	var variance float64
+	for _, r := range returns {
+		variance += (r - mean) * (r - mean)
+	}
+	variance /= float64(n - 1)
+
+	periods := float64(year) / (float64(span) / float64(n))
+	return math.Sqrt(variance * periods)
+}
+
+// XIRR 资金加权收益率（年化），即使现金流净现值为 0 的折现率。
+// 现金流需同时包含投入及取回，否则返回 ErrNoSolution
+func XIRR(flows []Flow) (float64, error) {
+	var in, out bool
+	for _, f := range flows {
+		in = in || f.Amount < 0
+		out = out || f.Amount > 0
+	}
+	if !in || !out {
+		return 0, ErrNoSolution
+	}
+
+	sorted := make([]Flow, len(flows))
+	copy(sorted, flows)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Time.Before(sorted[j].Time) })
+
+	t0 := sorted[0].Time
+	npv := func(rate float64) float64 {
+		var v float64
+		for _, f := range sorted {
+			v += f.Amount / math.Pow(1+rate, float64(f.Time.Sub(t0))/float64(year))
+		}
+		return v
+	}
+
+	// 净现值随折现率单调递减（先投入后取回），二分查找
+	lo, hi := -0.9999, 1.0
+	for npv(hi) > 0 {
+		if hi *= 2; hi > 1e9 {
+			return 0, ErrNoSolution
+		}
+	}
+	if npv(lo) < 0 {
+		return 0, ErrNoSolution
+	}
+
+	for i := 0; i < 200 && hi-lo > 1e-10; i++ {
+		mid := (lo + hi) / 2
+		if npv(mid) > 0 {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+
+	return (lo + hi) / 2, nil
+}
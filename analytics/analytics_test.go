@@ -0,0 +1,127 @@
+package analytics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/modood/aip/db"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// date 返回 UTC 日期
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+func TestXIRR(t *testing.T) {
+	Convey("should return the annual rate that zeroes the cash flows", t, func() {
+		r, err := XIRR([]Flow{
+			{Time: date(2018, 1, 1), Amount: -100},
+			{Time: date(2019, 1, 1), Amount: 110},
+		})
+		So(err, ShouldBeNil)
+		So(r, ShouldAlmostEqual, 0.1, 1e-6)
+
+		r, err = XIRR([]Flow{
+			{Time: date(2019, 1, 1), Amount: 50},
+			{Time: date(2018, 1, 1), Amount: -100},
+			{Time: date(2018, 7, 2), Amount: -100},
+		})
+		So(err, ShouldBeNil)
+		So(r, ShouldBeLessThan, -0.6)
+	})
+
+	Convey("should fail without both investments and returns", t, func() {
+		_, err := XIRR([]Flow{{Time: date(2018, 1, 1), Amount: -100}})
+		So(err, ShouldEqual, ErrNoSolution)
+	})
+}
+
+func TestAnalyze(t *testing.T) {
+	// 1 月 1 日以 100 买入 1 个，2 月 1 日以 50 再买入 1 个
+	orders := []*db.Order{
+		{ID: 2, Type: "buy-market", Price: 50, BaseAmount: 1.001, QuoteAmount: 50, Fees: 0.001,
+			FeeCurrency: "btc", Created: uint64(date(2018, 2, 1).Unix())},
+		{ID: 1, Type: "buy-market", Price: 100, BaseAmount: 1, QuoteAmount: 100,
+			Created: uint64(date(2018, 1, 1).Unix())},
+	}
+	prices := []Price{
+		{Time: date(2018, 1, 1), Price: 100},
+		{Time: date(2018, 1, 15), Price: 80},
+		{Time: date(2018, 2, 1), Price: 50},
+		{Time: date(2018, 3, 1), Price: 100},
+	}
+
+	Convey("should build points and flows by replaying the orders", t, func() {
+		points, flows := Build(orders, "btc", prices)
+		So(len(points), ShouldEqual, 4)
+		So(points[0].Equity, ShouldEqual, 0)
+		So(points[1].Equity, ShouldEqual, 80)
+		So(points[1].Price, ShouldEqual, 80)
+		So(points[2].Position, ShouldEqual, 1)
+		So(points[3].Position, ShouldAlmostEqual, 2, 1e-9)
+		So(points[3].Investment, ShouldEqual, 150)
+		So(len(flows), ShouldEqual, 2)
+		So(flows[1].Amount, ShouldEqual, -50)
+	})
+
+	Convey("should replay sells recorded with negative amounts", t, func() {
+		// 2 月 15 日以 100 卖出 0.5 个，与计划记录卖单的方式一致
+		sell := &db.Order{ID: 3, Type: "sell-market", Price: 100, BaseAmount: -0.5, QuoteAmount: -50,
+			Fees: 0.1, FeeCurrency: "usdt", Created: uint64(date(2018, 2, 15).Unix())}
+		points, flows := Build(append([]*db.Order{sell}, orders...), "btc", prices)
+		So(points[3].Position, ShouldAlmostEqual, 1.5, 1e-9)
+		So(points[3].Investment, ShouldAlmostEqual, 100.1, 1e-9)
+		So(len(flows), ShouldEqual, 3)
+		So(flows[2].Amount, ShouldAlmostEqual, 49.9, 1e-9)
+	})
+
+	Convey("should compute returns, drawdown and average cost", t, func() {
+		s := Analyze(Build(orders, "btc", prices))
+		So(s.Contributed, ShouldEqual, 150)
+		So(s.Gain, ShouldAlmostEqual, 50, 1e-9)
+		So(s.ROI, ShouldAlmostEqual, 1.0/3, 1e-9)
+		So(s.TWR, ShouldAlmostEqual, 0.875, 1e-9)
+		So(s.MaxDrawdown, ShouldAlmostEqual, -0.375, 1e-9)
+		So(s.AverageCost, ShouldAlmostEqual, 75, 1e-9)
+		So(s.XIRR, ShouldBeGreaterThan, 0)
+		So(s.Volatility, ShouldBeGreaterThan, 0)
+	})
+
+	Convey("should compute the drawdown of every point", t, func() {
+		points, _ := Build(orders, "btc", prices)
+		dd := Drawdowns(points)
+		So(len(dd), ShouldEqual, 4)
+		So(dd[1], ShouldEqual, 0)
+		So(dd[2], ShouldAlmostEqual, -0.375, 1e-9)
+		So(dd[3], ShouldEqual, 0)
+	})
+
+	Convey("should return zeros without points", t, func() {
+		So(Analyze(nil, nil), ShouldResemble, &Summary{})
+	})
+}
+
+func TestCompare(t *testing.T) {
+	Convey("should compare with a lump sum bought at the start", t, func() {
+		points := []Point{
+			{Equity: 100},
+			{Position: 3, Investment: 250, Equity: 300},
+		}
+		flows := []Flow{{Amount: -200}, {Amount: 50}}
+
+		b := Compare(points, flows, 100, 120)
+		So(b.Invested, ShouldEqual, 300)
+		So(b.DCA, ShouldEqual, 350)
+		So(b.LumpSum, ShouldEqual, 360)
+		So(b.Quote, ShouldEqual, 300)
+		So(b.Advantage, ShouldAlmostEqual, -10.0/300, 1e-9)
+	})
+
+	Convey("should not buy without an opening price", t, func() {
+		b := Compare([]Point{{Equity: 100}}, nil, 0, 120)
+		So(b.LumpSum, ShouldEqual, 0)
+		So(Compare(nil, nil, 100, 120), ShouldResemble, &Benchmark{})
+	})
+}
@@ -0,0 +1,257 @@
+package config
+
+import (
+	"time"
+
+	"github.com/modood/aip/db"
+	"github.com/modood/aip/notify"
+	"github.com/modood/aip/plan"
+	"github.com/modood/aip/util"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+)
+
+// DefaultPlan 未使用配置文件时，由命令行参数创建的计划名称
+const DefaultPlan = "default"
+
+var (
+	errUnkownPeriod = errors.New("unknown period")
+	errInvalidDay   = errors.New("day must be between 1 and 31")
+)
+
+// Config 配置
+type Config struct {
+	DBFile    string  // sqlite3 数据文件
+	DBURL     string  // PostgreSQL 连接地址，设置后不使用 sqlite3
+	Timezone  string  // 时区
+	APIHost   string  // 火币 API 地址
+	APIKey    string  // 火币 API key
+	APISecret string  // 火币 API secret
+	Plans     []*Plan // 定投计划
+
+	ShutdownTimeout   time.Duration // 退出时等待正在执行的任务的最长时间
+	Reconcile         time.Duration // 与交易所对账的间隔，0 表示不对账
+	ReconcileBackfill bool          // 对账时按交易所数据补录缺失及状态过期的订单
+	HTTP              string        // HTTP 接口及控制台的监听地址，为空时不启用
+	HTTPToken         string        // 暂停、恢复及立即投资接口的访问令牌，为空时禁用这些接口
+
+	StatisticsRetention int // 每小时统计的保留天数，之后按日汇总，0 表示不汇总
+}
+
+// Plan 定投计划配置
+type Plan struct {
+	Name     string  // 计划名称
+	Exchange string  // 交易所，目前仅支持 huobi
+	Symbol   string  // 交易品种
+	Amount   float64 // 每期金额
+	Period   Period  // 定投周期
+	Catchup  string  // 补投策略
+	Notify   Notify  // 通知
+}
+
+// Period 定投周期配置
+type Period struct {
+	Type    string        // 周期类型
+	Time    string        // 执行时刻 hh:mm[:ss]
+	Weekday string        // 星期，按周、双周时有效
+	Day     int           // 日期，按月时有效
+	Cron    string        // cron 表达式，自定义周期时有效
+	Every   time.Duration // 间隔，固定间隔周期时有效
+	Window  time.Duration // 执行窗口
+}
+
+// Notify 通知配置
+type Notify struct {
+	Webhook string // webhook 地址，为空时不通知
+}
+
+// Load 加载配置，配置文件中没有 plans 时由命令行参数创建默认计划
+func Load(v *viper.Viper) (*Config, error) {
+	c := &Config{
+		DBFile:    v.GetString("dbfile"),
+		DBURL:     v.GetString("dburl"),
+		Timezone:  v.GetString("timezone"),
+		APIHost:   v.GetString("apihost"),
+		APIKey:    v.GetString("apikey"),
+		APISecret: v.GetString("apisecret"),
+
+		ShutdownTimeout:   v.GetDuration("shutdown-timeout"),
+		Reconcile:         v.GetDuration("reconcile"),
+		ReconcileBackfill: v.GetBool("reconcile-backfill"),
+		HTTP:              v.GetString("http"),
+		HTTPToken:         v.GetString("http-token"),
+
+		StatisticsRetention: v.GetInt("statistics-retention"),
+	}
+
+	if v.IsSet("plans") {
+		if err := v.UnmarshalKey("plans", &c.Plans); err != nil {
+			return nil, errors.Wrap(err, util.FuncName())
+		}
+	} else {
+		c.Plans = []*Plan{{
+			Name:    DefaultPlan,
+			Symbol:  v.GetString("symbol"),
+			Amount:  v.GetFloat64("amount"),
+			Catchup: v.GetString("catchup"),
+			Period: Period{
+				Type:    v.GetString("period"),
+				Time:    v.GetString("time"),
+				Weekday: v.GetString("weekday"),
+				Day:     v.GetInt("day"),
+				Cron:    v.GetString("cron"),
+				Every:   v.GetDuration("every"),
+				Window:  v.GetDuration("window"),
+			},
+			Notify: Notify{
+				Webhook: v.GetString("webhook"),
+			},
+		}}
+	}
+
+	for _, p := range c.Plans {
+		if p != nil {
+			p.setDefaults()
+		}
+	}
+
+	return c, nil
+}
+
+// Plan 根据名称查找计划配置
+func (c *Config) Plan(name string) *Plan {
+	for _, p := range c.Plans {
+		if p.Name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// Database 返回数据库驱动及连接参数，设置了 PostgreSQL 连接地址时使用 PostgreSQL
+func (c *Config) Database() (driver, source string) {
+	if c.DBURL != "" {
+		return db.DriverPostgres, c.DBURL
+	}
+	return db.DriverSQLite, c.DBFile
+}
+
+// Location 返回配置的时区
+func (c *Config) Location() (*time.Location, error) {
+	loc, err := time.LoadLocation(c.Timezone)
+	if err != nil {
+		return nil, errors.Wrap(err, util.FuncName())
+	}
+	return loc, nil
+}
+
+// setDefaults 设置默认值
+func (p *Plan) setDefaults() {
+	if p.Exchange == "" {
+		p.Exchange = "huobi"
+	}
+	if p.Catchup == "" {
+		p.Catchup = string(plan.CatchupSkip)
+	}
+	if p.Period.Type == "" {
+		p.Period.Type = "daily"
+	}
+	if p.Period.Time == "" {
+		p.Period.Time = "00:00:00"
+	}
+	if p.Period.Weekday == "" {
+		p.Period.Weekday = "monday"
+	}
+	if p.Period.Day == 0 {
+		p.Period.Day = 1
+	}
+}
+
+// Options 返回创建定投计划的参数
+func (p *Plan) Options() (*plan.Options, error) {
+	period, err := p.NewPeriod()
+	if err != nil {
+		return nil, errors.Wrap(err, util.FuncName())
+	}
+
+	notifier, err := p.NewNotifier()
+	if err != nil {
+		return nil, errors.Wrap(err, util.FuncName())
+	}
+
+	return &plan.Options{
+		Name:     p.Name,
+		Symbol:   p.Symbol,
+		Amount:   p.Amount,
+		Period:   period,
+		Catchup:  plan.CatchupPolicy(p.Catchup),
+		Notifier: notifier,
+	}, nil
+}
+
+// NewPeriod 创建定投周期，执行窗口以计划名称为随机种子
+func (p *Plan) NewPeriod() (plan.Period, error) {
+	var (
+		period  plan.Period
+		weekday time.Weekday
+	)
+
+	c := p.Period
+	hour, minute, second, err := plan.ParseClock(c.Time)
+	if err != nil {
+		return nil, errors.Wrap(err, util.FuncName())
+	}
+
+	switch c.Type {
+	case "daily":
+		period, err = plan.NewDaily(hour, minute, second)
+	case "weekly":
+		if weekday, err = plan.ParseWeekday(c.Weekday); err == nil {
+			period, err = plan.NewWeekly(weekday, hour, minute, second)
+		}
+	case "biweekly":
+		if weekday, err = plan.ParseWeekday(c.Weekday); err == nil {
+			period, err = plan.NewBiweekly(weekday, hour, minute, second)
+		}
+	case "monthly":
+		if c.Day < 1 || c.Day > 31 {
+			return nil, errors.Wrap(errInvalidDay, util.FuncName())
+		}
+		period, err = plan.NewMonthly(uint8(c.Day), hour, minute, second)
+	case "monthend":
+		period, err = plan.NewMonthEnd(hour, minute, second)
+	case "cron":
+		period, err = plan.NewCron(c.Cron)
+	case "every":
+		period, err = plan.NewEvery(c.Every)
+	default:
+		err = errors.Wrap(errUnkownPeriod, c.Type)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, util.FuncName())
+	}
+
+	// 以交易品种为种子，与单计划时的执行时间保持一致
+	if c.Window > 0 {
+		period, err = plan.NewWindow(period, c.Window, p.Symbol)
+		if err != nil {
+			return nil, errors.Wrap(err, util.FuncName())
+		}
+	}
+
+	return period, nil
+}
+
+// NewNotifier 创建通知，未配置时返回 nil
+func (p *Plan) NewNotifier() (notify.Notifier, error) {
+	if p.Notify.Webhook == "" {
+		return nil, nil
+	}
+
+	n, err := notify.NewWebhook(p.Notify.Webhook)
+	if err != nil {
+		return nil, errors.Wrap(err, util.FuncName())
+	}
+	return n, nil
+}
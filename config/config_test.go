@@ -0,0 +1,210 @@
+package config
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/modood/aip/huobi"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const yaml = `
+dbfile: /tmp/aip.sqlite3
+timezone: Europe/Berlin
+apihost: https://api.huobi.pro
+apikey: apikey
+apisecret: apisecret
+shutdown-timeout: 1m
+reconcile: 30m
+http: 127.0.0.1:8080
+statistics-retention: 90
+plans:
+  - name: btc
+    symbol: btcusdt
+    amount: 20
+    period:
+      type: daily
+      time: "08:00"
+      window: 2h
+    catchup: merge
+  - name: eth
+    symbol: ethusdt
+    amount: 100
+    period:
+      type: monthly
+      day: 31
+`
+
+func TestLoad(t *testing.T) {
+	Convey("should load named plans from config file", t, func() {
+		v := viper.New()
+		v.SetConfigType("yaml")
+		So(v.ReadConfig(bytes.NewBufferString(yaml)), ShouldBeNil)
+
+		c, err := Load(v)
+		So(err, ShouldBeNil)
+		So(c.Validate(), ShouldBeNil)
+		So(c.Timezone, ShouldEqual, "Europe/Berlin")
+		So(c.ShutdownTimeout, ShouldEqual, time.Minute)
+		So(c.Reconcile, ShouldEqual, 30*time.Minute)
+		So(c.ReconcileBackfill, ShouldBeFalse)
+		So(c.HTTP, ShouldEqual, "127.0.0.1:8080")
+		So(c.HTTPToken, ShouldBeEmpty)
+		So(c.StatisticsRetention, ShouldEqual, 90)
+		So(len(c.Plans), ShouldEqual, 2)
+
+		btc := c.Plan("btc")
+		So(btc.Exchange, ShouldEqual, "huobi")
+		So(btc.Period.Window, ShouldEqual, 2*time.Hour)
+		So(btc.Catchup, ShouldEqual, "merge")
+
+		eth := c.Plan("eth")
+		So(eth.Catchup, ShouldEqual, "skip")
+		So(eth.Period.Time, ShouldEqual, "00:00:00")
+
+		p, err := eth.NewPeriod()
+		So(err, ShouldBeNil)
+		So(p.Schedule(), ShouldEqual, "0 0 0 31 * *")
+	})
+
+	Convey("should create default plan from flags without plans", t, func() {
+		v := viper.New()
+		v.Set("dbfile", "/tmp/aip.sqlite3")
+		v.Set("timezone", "Asia/Chongqing")
+		v.Set("apihost", "https://api.huobi.pro")
+		v.Set("apikey", "apikey")
+		v.Set("apisecret", "apisecret")
+		v.Set("shutdown-timeout", "30s")
+		v.Set("symbol", "btcusdt")
+		v.Set("amount", 10)
+		v.Set("period", "weekly")
+
+		c, err := Load(v)
+		So(err, ShouldBeNil)
+		So(c.Validate(), ShouldBeNil)
+		So(len(c.Plans), ShouldEqual, 1)
+		So(c.Plans[0].Name, ShouldEqual, DefaultPlan)
+	})
+}
+
+func TestValidate(t *testing.T) {
+	Convey("should report all problems at once", t, func() {
+		c := &Config{
+			DBFile:   "/tmp/aip.sqlite3",
+			Timezone: "Mars/Olympus",
+			APIHost:  "https://api.huobi.pro",
+
+			Reconcile: -time.Hour,
+			HTTP:      "8080",
+
+			StatisticsRetention: -1,
+			Plans: []*Plan{
+				{Name: "btc", Symbol: "btcusdt", Amount: 10},
+				{Name: "btc", Symbol: "BTC/USDT", Amount: -1},
+				{Name: "eth", Symbol: "ethusdt", Amount: 10, Catchup: "later",
+					Period: Period{Type: "monthly", Day: 32}},
+			},
+		}
+		for _, p := range c.Plans {
+			p.setDefaults()
+		}
+
+		err := c.Validate()
+		So(err, ShouldNotBeNil)
+
+		problems, ok := err.(Problems)
+		So(ok, ShouldBeTrue)
+		So(problems, ShouldResemble, Problems{
+			`timezone "Mars/Olympus" is not a valid IANA time zone`,
+			`shutdown-timeout must be greater than 0, got 0s`,
+			`reconcile must not be negative, got -1h0m0s`,
+			`statistics-retention must not be negative, got -1`,
+			`http "8080" must be a listen address, e.g. :8080 or 127.0.0.1:8080`,
+			`plan "btc": name is already used by plans[0]`,
+			`plan "btc": symbol "BTC/USDT" must be lowercase base and quote currency, e.g. btcusdt`,
+			`plan "eth": catchup "later" is unknown, available: skip, execute and merge`,
+			`plan "eth": period: day must be between 1 and 31, got 32`,
+		})
+	})
+
+	Convey("should reject window longer than the period", t, func() {
+		c := &Config{
+			DBFile:    "/tmp/aip.sqlite3",
+			Timezone:  "UTC",
+			APIHost:   "https://api.huobi.pro",
+			APIKey:    "apikey",
+			APISecret: "apisecret",
+
+			ShutdownTimeout: time.Minute,
+			Plans: []*Plan{{Name: "btc", Symbol: "btcusdt", Amount: 10,
+				Period: Period{Type: "every", Every: time.Hour, Window: 2 * time.Hour}}},
+		}
+		c.Plans[0].setDefaults()
+
+		err := c.Validate()
+		So(err, ShouldNotBeNil)
+		So(len(err.(Problems)), ShouldEqual, 1)
+	})
+}
+
+type market map[string]*huobi.Symbol
+
+func (m market) Symbol(name string) (*huobi.Symbol, error) {
+	if name == "timeoutusdt" {
+		return nil, errors.New("i/o timeout")
+	}
+	if s, ok := m[name]; ok {
+		return s, nil
+	}
+	return nil, errors.Wrap(&huobi.APIError{Code: "base-symbol-error", Message: "invalid symbol"}, "Symbol")
+}
+
+func TestValidateMarket(t *testing.T) {
+	Convey("should check symbol and minimum order value", t, func() {
+		m := market{"btcusdt": {Symbol: "btcusdt", QuoteCurrency: "usdt", MinOrderValue: 5}}
+		c := &Config{Plans: []*Plan{
+			{Name: "btc", Exchange: "huobi", Symbol: "btcusdt", Amount: 1},
+			{Name: "doge", Exchange: "huobi", Symbol: "dogeusdt", Amount: 10},
+		}}
+
+		err := c.ValidateMarket(m)
+		So(err, ShouldNotBeNil)
+		So(err.(Problems), ShouldResemble, Problems{
+			`plan "btc": amount 1 is less than the minimum order value 5 usdt of btcusdt`,
+			`plan "doge": symbol "dogeusdt" is not traded on huobi`,
+		})
+
+		c.Plans = c.Plans[:1]
+		c.Plans[0].Amount = 20
+		So(c.ValidateMarket(m), ShouldBeNil)
+	})
+
+	Convey("should return other errors as they are", t, func() {
+		c := &Config{Plans: []*Plan{{Name: "timeout", Exchange: "huobi", Symbol: "timeoutusdt", Amount: 10}}}
+		err := c.ValidateMarket(market{})
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldEndWith, "i/o timeout")
+		_, ok := errors.Cause(err).(Problems)
+		So(ok, ShouldBeFalse)
+	})
+}
+
+func TestValidateTrade(t *testing.T) {
+	Convey("should require the api key, secret and amounts", t, func() {
+		c := &Config{Plans: []*Plan{{Name: "btc", Amount: 10}, {Name: "eth"}, {Amount: -1}}}
+		So(c.ValidateTrade(), ShouldResemble, Problems{
+			`apikey is required`,
+			`apisecret is required`,
+			`plan "eth": amount must be greater than 0, got 0`,
+			`plans[2]: amount must be greater than 0, got -1`,
+		})
+
+		c = &Config{APIKey: "apikey", APISecret: "apisecret", Plans: []*Plan{{Name: "btc", Amount: 10}}}
+		So(c.ValidateTrade(), ShouldBeNil)
+	})
+}
@@ -0,0 +1,477 @@
+package main
+
+import (
+	"log"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/modood/aip/config"
+	"github.com/modood/aip/db"
+	"github.com/modood/aip/huobi"
+	"github.com/modood/aip/plan"
+	"github.com/modood/aip/util"
+
+	"github.com/pkg/errors"
+	"github.com/robfig/cron"
+)
+
+var (
+	errShutdownTimeout = errors.New("timed out waiting for running jobs")
+	errPlanNotRunning  = errors.New("plan is not running in this instance")
+	errShuttingDown    = errors.New("shutting down")
+)
+
+// maxFailures 保留的最近错误条数
+const maxFailures = 50
+
+// compactInterval 汇总过期统计的间隔，统计按日汇总，没有过期统计时只需一次查询
+const compactInterval = time.Hour
+
+// daemon 定投守护进程，每个计划使用独立的调度器
+type daemon struct {
+	mu      sync.Mutex
+	client  *huobi.Client
+	store   db.Store
+	loc     *time.Location
+	owner   string         // 实例标识，用于计划租约
+	cfg     *config.Config // 当前应用的配置
+	workers map[string]*worker
+	guards  map[string]*sync.Mutex // 同名计划的执行锁，配置变更前后的调度器不会同时投资；持有时不能获取 mu
+	paused  map[string]bool        // 暂停投资的计划，重启后恢复
+	checker *cron.Cron             // 定期与交易所对账，未启用时为 nil
+	retain  *cron.Cron             // 定期汇总过期的统计，未启用时为 nil
+
+	fmu      sync.Mutex // 保护 failures
+	failures []*failure // 最近的任务错误，按时间顺序
+
+	jmu    sync.Mutex     // 保护 closed，与 jobs.Add 互斥
+	closed bool           // 已开始退出，不再执行新任务
+	jobs   sync.WaitGroup // 正在执行的投资及监控任务
+}
+
+// failure 任务错误
+type failure struct {
+	Time  time.Time // 发生时间
+	Plan  string    // 定投计划，对账等全局任务为空
+	Job   string    // 任务：invest, monitor, lease, reconcile, compact
+	Error string    // 错误信息
+}
+
+// worker 单个定投计划的调度器
+type worker struct {
+	config  *config.Plan
+	plan    plan.Plan
+	guard   *sync.Mutex
+	invest  *cron.Cron
+	monitor *cron.Cron
+	lease   *cron.Cron // 续期计划租约，待命时重试获取
+	standby bool       // 租约由其他实例持有，暂不投资及监控，由 d.mu 保护
+}
+
+func newDaemon(client *huobi.Client, store db.Store, loc *time.Location) *daemon {
+	return &daemon{
+		client:  client,
+		store:   store,
+		loc:     loc,
+		owner:   instanceID(),
+		workers: make(map[string]*worker),
+		guards:  make(map[string]*sync.Mutex),
+		paused:  make(map[string]bool),
+	}
+}
+
+// apply 应用配置：停止已删除的计划，启动新增的计划，重启有变更的计划。
+// 创建失败的计划保持原有调度，返回最后一个错误
+func (d *daemon) apply(cfg *config.Config) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.isClosed() {
+		return nil
+	}
+	d.cfg = cfg
+
+	for name := range d.workers {
+		if cfg.Plan(name) == nil {
+			d.stop(name)
+			if err := d.store.ReleaseLease(name, d.owner); err != nil {
+				log.Println(err)
+			}
+		}
+	}
+
+	var err error
+	for _, c := range cfg.Plans {
+		old, ok := d.workers[c.Name]
+		if ok && reflect.DeepEqual(old.config, c) {
+			continue
+		}
+
+		w, e := d.build(c)
+		if e != nil {
+			err = errors.Wrap(e, util.FuncName())
+			log.Println(err)
+			continue
+		}
+
+		if ok {
+			d.stop(c.Name)
+		}
+		if e = d.start(w); e != nil {
+			err = errors.Wrap(e, util.FuncName())
+			log.Println(err)
+		}
+	}
+
+	d.schedule(cfg)
+
+	return err
+}
+
+// schedule 按配置重新调度对账及统计汇总任务
+func (d *daemon) schedule(cfg *config.Config) {
+	if d.retain != nil {
+		d.retain.Stop()
+		d.retain = nil
+	}
+	if cfg.StatisticsRetention > 0 {
+		retention := cfg.StatisticsRetention
+		job := d.job(func() {
+			n, before, err := compact(d.store, retention, time.Now().In(d.loc))
+			if err != nil {
+				d.fail("", "compact", err)
+				return
+			}
+			if n > 0 {
+				log.Printf("rolled up %d statistics before %s to daily ones\n", n, before.Format(dateLayout))
+			}
+		})
+		d.retain = cron.NewWithLocation(d.loc)
+		d.retain.Schedule(cron.Every(compactInterval), job)
+		d.retain.Start()
+		go job.Run() // 先汇总一次，频繁重启时也能按时汇总
+	}
+
+	if d.checker != nil {
+		d.checker.Stop()
+		d.checker = nil
+	}
+	if cfg.Reconcile <= 0 {
+		return
+	}
+
+	rc := &reconciler{client: d.client, store: d.store, plans: cfg.Plans, backfill: cfg.ReconcileBackfill}
+	d.checker = cron.NewWithLocation(d.loc)
+	d.checker.Schedule(cron.Every(cfg.Reconcile), d.job(func() {
+		found, err := rc.run(time.Now().In(d.loc))
+		if err != nil {
+			d.fail("", "reconcile", err)
+			return
+		}
+		for _, r := range found {
+			log.Printf("reconcile: %s\n", describe(r))
+		}
+	}))
+	d.checker.Start()
+}
+
+// build 创建定投计划的调度器
+func (d *daemon) build(c *config.Plan) (*worker, error) {
+	opts, err := c.Options()
+	if err != nil {
+		return nil, errors.Wrap(err, util.FuncName())
+	}
+
+	p, err := plan.New(opts, d.client, d.store)
+	if err != nil {
+		return nil, errors.Wrap(err, util.FuncName())
+	}
+
+	guard, ok := d.guards[c.Name]
+	if !ok {
+		guard = &sync.Mutex{}
+		d.guards[c.Name] = guard
+	}
+
+	w := &worker{
+		config:  c,
+		plan:    p,
+		guard:   guard,
+		invest:  cron.NewWithLocation(d.loc),
+		monitor: cron.NewWithLocation(d.loc),
+		lease:   cron.NewWithLocation(d.loc),
+	}
+
+	w.invest.Schedule(p.Period(), d.job(func() { d.execute(w) }))
+
+	if err = w.monitor.AddJob("0 0 * * * *", d.job(func() {
+		if err := p.Monitor(); err != nil {
+			d.fail(c.Name, "monitor", err)
+		}
+	})); err != nil {
+		return nil, errors.Wrap(err, util.FuncName())
+	}
+
+	w.lease.Schedule(cron.Every(leaseTTL/3), d.job(func() { d.renew(w) }))
+
+	return w, nil
+}
+
+// execute 执行到期的定投。先读取暂停状态再获取执行锁：持有 d.mu 时会获取执行锁（见 activate），
+// 持有执行锁时不能再获取 d.mu，否则与配置变更及续期互相等待
+func (d *daemon) execute(w *worker) {
+	name := w.plan.Name()
+	paused := d.isPaused(name)
+
+	w.guard.Lock()
+	defer w.guard.Unlock()
+
+	// 投资前确认仍持有租约，避免与其他实例重复投资
+	if err := d.acquire(name); err != nil {
+		d.fail(name, "invest", err)
+		return
+	}
+
+	// 暂停期间跳过到期的周期，恢复后不会补投
+	if paused {
+		log.Printf("plan %s is paused, skipping this run\n", name)
+		if err := w.plan.Skip(time.Now().In(d.loc)); err != nil {
+			d.fail(name, "invest", err)
+		}
+		return
+	}
+
+	if err := w.plan.Execute(time.Now().In(d.loc)); err != nil {
+		d.fail(name, "invest", err)
+	}
+}
+
+// start 获取租约，补投错过的周期后开始调度。租约由其他实例持有时转为待命，
+// 由续期任务重试获取，获取失败的错误同样返回
+func (d *daemon) start(w *worker) error {
+	name := w.plan.Name()
+
+	w.lease.Start()
+	d.workers[name] = w
+
+	if err := d.acquire(name); err != nil {
+		w.standby = true
+		log.Printf("plan %s is standing by until its lease can be acquired\n", name)
+		return errors.Wrap(err, util.FuncName())
+	}
+
+	d.activate(w)
+
+	return nil
+}
+
+// activate 补投错过的周期后开始投资及监控，调用者需持有租约及 d.mu
+func (d *daemon) activate(w *worker) {
+	name := w.plan.Name()
+
+	w.guard.Lock()
+	if err := w.plan.Catchup(time.Now().In(d.loc)); err != nil {
+		log.Println(err)
+	}
+	w.guard.Unlock()
+
+	w.standby = false
+	w.invest.Start()
+	w.monitor.Start()
+
+	log.Printf("plan %s started, next run at %s\n", name,
+		w.plan.Period().Next(time.Now().In(d.loc)).Format(time.RFC3339))
+}
+
+// renew 续期计划的租约。租约被其他实例接管时停止投资并转为待命，待命的计划获取租约后开始投资；
+// 其他错误（如数据库暂时被锁）只记录，下次继续重试
+func (d *daemon) renew(w *worker) {
+	name := w.plan.Name()
+	err := d.acquire(name)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.workers[name] != w {
+		return // 计划已停止或已按新配置重启
+	}
+
+	switch {
+	case err == nil:
+		if w.standby {
+			d.activate(w)
+		}
+	case errors.Cause(err) == errPlanRunning:
+		if !w.standby {
+			d.fail(name, "lease", err)
+			w.invest.Stop()
+			w.monitor.Stop()
+			w.standby = true
+			log.Printf("plan %s lost its lease, standing by\n", name)
+		}
+	default:
+		d.fail(name, "lease", err)
+	}
+}
+
+// stop 停止定投计划的调度，已开始的投资会继续执行完
+func (d *daemon) stop(name string) {
+	w, ok := d.workers[name]
+	if !ok {
+		return
+	}
+
+	w.invest.Stop()
+	w.monitor.Stop()
+	w.lease.Stop()
+	delete(d.workers, name)
+
+	log.Printf("plan %s stopped\n", name)
+}
+
+// pause 暂停或恢复计划的定时投资，计划不存在时返回错误
+func (d *daemon) pause(name string, paused bool) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.cfg == nil || d.cfg.Plan(name) == nil {
+		return errors.Wrap(errPlanNotFound, name)
+	}
+
+	if paused {
+		d.paused[name] = true
+		log.Printf("plan %s paused\n", name)
+	} else {
+		delete(d.paused, name)
+		log.Printf("plan %s resumed\n", name)
+	}
+
+	return nil
+}
+
+// isPaused 计划是否已暂停
+func (d *daemon) isPaused(name string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.paused[name]
+}
+
+// investNow 立即为计划投资一次，与定时投资互斥，不影响定投周期
+func (d *daemon) investNow(name string) error {
+	d.mu.Lock()
+	w, ok := d.workers[name]
+	standby := ok && w.standby
+	d.mu.Unlock()
+	if !ok {
+		return errors.Wrap(errPlanNotRunning, name)
+	}
+	if standby {
+		return errors.Wrap(errPlanRunning, name)
+	}
+
+	err := errShuttingDown
+	d.job(func() {
+		w.guard.Lock()
+		defer w.guard.Unlock()
+
+		if err = d.acquire(name); err != nil {
+			return
+		}
+		err = w.plan.Invest()
+	}).Run()
+	if err != nil {
+		d.fail(name, "invest", err)
+		return errors.Wrap(err, util.FuncName())
+	}
+
+	log.Printf("plan %s invested now\n", name)
+
+	return nil
+}
+
+// fail 记录并输出任务错误，只保留最近 maxFailures 条
+func (d *daemon) fail(plan, job string, err error) {
+	log.Println(err)
+
+	d.fmu.Lock()
+	defer d.fmu.Unlock()
+
+	d.failures = append(d.failures, &failure{Time: time.Now(), Plan: plan, Job: job, Error: err.Error()})
+	if len(d.failures) > maxFailures {
+		d.failures = d.failures[len(d.failures)-maxFailures:]
+	}
+}
+
+// lastFailures 返回最近的任务错误，最新的在前；plan 不为空时只返回该计划的错误
+func (d *daemon) lastFailures(plan string) []*failure {
+	d.fmu.Lock()
+	defer d.fmu.Unlock()
+
+	var r []*failure
+	for i := len(d.failures) - 1; i >= 0; i-- {
+		if plan == "" || d.failures[i].Plan == plan {
+			r = append(r, d.failures[i])
+		}
+	}
+	return r
+}
+
+// job 包装调度任务，记录正在执行的任务，开始退出后不再执行
+func (d *daemon) job(fn func()) cron.FuncJob {
+	return func() {
+		d.jmu.Lock()
+		if d.closed {
+			d.jmu.Unlock()
+			return
+		}
+		d.jobs.Add(1)
+		d.jmu.Unlock()
+
+		defer d.jobs.Done()
+		fn()
+	}
+}
+
+// isClosed 是否已开始退出
+func (d *daemon) isClosed() bool {
+	d.jmu.Lock()
+	defer d.jmu.Unlock()
+	return d.closed
+}
+
+// shutdown 停止所有计划的调度，等待正在执行的任务完成后释放租约，超时返回错误
+func (d *daemon) shutdown(timeout time.Duration) error {
+	d.jmu.Lock()
+	d.closed = true
+	d.jmu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		// 等待正在进行的配置变更及补投完成
+		d.mu.Lock()
+		for name := range d.workers {
+			d.stop(name)
+		}
+		if d.checker != nil {
+			d.checker.Stop()
+		}
+		if d.retain != nil {
+			d.retain.Stop()
+		}
+		d.mu.Unlock()
+
+		d.jobs.Wait()
+		if err := d.store.ReleaseLease("", d.owner); err != nil {
+			log.Println(err)
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-time.After(timeout):
+		return errors.Wrap(errShutdownTimeout, util.FuncName())
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/modood/aip/config"
+	"github.com/modood/aip/db"
+	"github.com/modood/aip/plan"
+
+	"github.com/pkg/errors"
+	"github.com/robfig/cron"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestShutdown(t *testing.T) {
+	Convey("should wait for running jobs", t, func() {
+		store, err := db.Init(db.DriverSQLite, "/tmp/aip.sqlite3")
+		So(err, ShouldBeNil)
+		defer store.Close()
+		d := newDaemon(nil, store, time.UTC)
+
+		var finished int32
+		go d.job(func() {
+			time.Sleep(100 * time.Millisecond)
+			atomic.StoreInt32(&finished, 1)
+		}).Run()
+		time.Sleep(10 * time.Millisecond)
+
+		So(d.shutdown(time.Second), ShouldBeNil)
+		So(atomic.LoadInt32(&finished), ShouldEqual, 1)
+
+		var ran int32
+		d.job(func() { atomic.StoreInt32(&ran, 1) }).Run()
+		So(atomic.LoadInt32(&ran), ShouldEqual, 0)
+	})
+
+	Convey("should give up after timeout", t, func() {
+		store, err := db.Init(db.DriverSQLite, "/tmp/aip.sqlite3")
+		So(err, ShouldBeNil)
+		defer store.Close()
+		d := newDaemon(nil, store, time.UTC)
+
+		go d.job(func() { time.Sleep(time.Second) }).Run()
+		time.Sleep(10 * time.Millisecond)
+
+		So(d.shutdown(50*time.Millisecond), ShouldNotBeNil)
+	})
+}
+
+func TestAcquire(t *testing.T) {
+	Convey("should refuse a plan locked by another instance", t, func() {
+		store, err := db.Init(db.DriverSQLite, "/tmp/aip.sqlite3")
+		So(err, ShouldBeNil)
+		defer store.Close()
+		name := "acquire" + time.Now().Format("150405.000")
+
+		a, b := newDaemon(nil, store, time.UTC), newDaemon(nil, store, time.UTC)
+		b.owner = "elsewhere/1"
+
+		So(a.acquire(name), ShouldBeNil)
+		So(a.acquire(name), ShouldBeNil)
+
+		err = b.acquire(name)
+		So(errors.Cause(err), ShouldEqual, errPlanRunning)
+
+		So(a.shutdown(time.Second), ShouldBeNil)
+		So(b.acquire(name), ShouldBeNil)
+	})
+
+	Convey("should take over the lock of an exited local process", t, func() {
+		store, err := db.Init(db.DriverSQLite, "/tmp/aip.sqlite3")
+		So(err, ShouldBeNil)
+		defer store.Close()
+		name := "takeover" + time.Now().Format("150405.000")
+
+		cmd := exec.Command("true")
+		So(cmd.Run(), ShouldBeNil)
+
+		a, b := newDaemon(nil, store, time.UTC), newDaemon(nil, store, time.UTC)
+		a.owner = fmt.Sprintf("%s/%d", strings.Split(a.owner, "/")[0], cmd.Process.Pid)
+
+		So(a.acquire(name), ShouldBeNil)
+		So(b.acquire(name), ShouldBeNil)
+	})
+}
+
+// idle 不做任何事的定投计划，用于测试调度
+type idle struct {
+	name   string
+	period plan.Period
+}
+
+func (p *idle) Name() string                { return p.name }
+func (p *idle) Period() plan.Period         { return p.period }
+func (p *idle) Invest() error               { return nil }
+func (p *idle) Execute(now time.Time) error { return nil }
+func (p *idle) Catchup(now time.Time) error { return nil }
+func (p *idle) Skip(now time.Time) error    { return nil }
+func (p *idle) Monitor() error              { return nil }
+func (p *idle) State() plan.State           { return plan.State{} }
+
+func TestStandby(t *testing.T) {
+	Convey("should stand by while another instance holds the lease and take over after it", t, func() {
+		store, err := db.Init(db.DriverSQLite, "/tmp/aip.sqlite3")
+		So(err, ShouldBeNil)
+		defer store.Close()
+		name := "standby" + time.Now().Format("150405.000")
+
+		a, b := newDaemon(nil, store, time.UTC), newDaemon(nil, store, time.UTC)
+		b.owner = "elsewhere/1"
+		So(b.acquire(name), ShouldBeNil)
+
+		period, err := plan.NewEvery(time.Hour)
+		So(err, ShouldBeNil)
+		w := &worker{config: &config.Plan{Name: name}, plan: &idle{name, period}, guard: &sync.Mutex{},
+			invest: cron.New(), monitor: cron.New(), lease: cron.New()}
+		defer a.shutdown(time.Second)
+
+		a.mu.Lock()
+		err = a.start(w)
+		a.mu.Unlock()
+		So(errors.Cause(err), ShouldEqual, errPlanRunning)
+		So(w.standby, ShouldBeTrue)
+		So(errors.Cause(a.investNow(name)), ShouldEqual, errPlanRunning)
+
+		// 其他实例仍持有租约时继续待命
+		a.renew(w)
+		So(w.standby, ShouldBeTrue)
+		So(a.workers[name], ShouldEqual, w)
+
+		So(b.shutdown(time.Second), ShouldBeNil)
+		a.renew(w)
+		So(w.standby, ShouldBeFalse)
+
+		// 租约被接管后转为待命，不停止续期
+		So(store.TakeoverLease(name, "elsewhere/2", a.owner, uint64(time.Now().Add(leaseTTL).Unix())), ShouldBeNil)
+		a.renew(w)
+		So(w.standby, ShouldBeTrue)
+		So(a.workers[name], ShouldEqual, w)
+		So(len(a.lastFailures(name)), ShouldEqual, 1)
+	})
+}
+
+func TestReloadDuringInvest(t *testing.T) {
+	Convey("should not deadlock when a plan restarts while an invest waits", t, func() {
+		store, err := db.Init(db.DriverSQLite, "/tmp/aip.sqlite3")
+		So(err, ShouldBeNil)
+		defer store.Close()
+		name := "reload" + time.Now().Format("150405.000")
+
+		d := newDaemon(nil, store, time.UTC)
+		defer d.shutdown(time.Second)
+		period, err := plan.NewEvery(time.Hour)
+		So(err, ShouldBeNil)
+		guard := &sync.Mutex{}
+		worker := func() *worker {
+			return &worker{config: &config.Plan{Name: name}, plan: &idle{name, period}, guard: guard,
+				invest: cron.New(), monitor: cron.New(), lease: cron.New()}
+		}
+		old := worker()
+
+		// 配置变更持有 d.mu 期间，到期的投资开始执行
+		d.mu.Lock()
+		invested := make(chan struct{})
+		go func() {
+			d.execute(old)
+			close(invested)
+		}()
+		time.Sleep(50 * time.Millisecond)
+
+		started := make(chan error, 1)
+		go func() {
+			started <- d.start(worker())
+			d.mu.Unlock()
+		}()
+
+		select {
+		case err := <-started:
+			So(err, ShouldBeNil)
+		case <-time.After(time.Second):
+			So("deadlock", ShouldBeEmpty)
+		}
+		select {
+		case <-invested:
+		case <-time.After(time.Second):
+			So("deadlock", ShouldBeEmpty)
+		}
+	})
+}
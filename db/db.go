@@ -2,137 +2,200 @@ package db
 
 import (
 	"database/sql"
+	"log"
+	"time"
 
 	"github.com/modood/aip/util"
 
-	_ "github.com/mattn/go-sqlite3" // justifying
 	"github.com/pkg/errors"
 )
 
-var db *sql.DB
+// 数据库驱动
+const (
+	DriverSQLite   = "sqlite3"  // sqlite3 数据文件，默认
+	DriverPostgres = "postgres" // PostgreSQL，多个实例共用数据时使用
+)
+
+var errUnknownDriver = errors.New("unknown database driver")
+
+// Store 数据存储接口，时间统一以 UTC 存储，参数及返回值为 unix 时间
+type Store interface {
+	AddPlan(name string) (uint64, error) // 新增定投计划，已存在时忽略，返回计划编号
+	Plans() ([]*Plan, error)             // 返回全部定投计划
+
+	AddOrder(order *Order) error                                                // 新增订单
+	SaveOrder(order *Order) (created bool, err error)                           // 新增或更新订单
+	Orders(f *Filter) ([]*Order, error)                                         // 按条件查询订单
+	OrderSummary(plan, symbol string) (position, investment float64, err error) // 扣除手续费后的订单汇总
+
+	AddStatistics(statistics *Statistics) error                           // 新增统计
+	ListStatistics(f *Filter) ([]*Statistics, error)                      // 按条件查询统计，包含按日汇总的统计
+	CompactStatistics(before uint64, loc *time.Location) (int, error)     // 将 before 当日零点之前的统计按 loc 时区的日期汇总
+	AddExecution(execution *Execution) error                              // 新增执行记录
+	LastExecution(plan string) (*Execution, error)                        // 最近一次非失败的执行记录
+	SaveReconciliation(r *Reconciliation) error                           // 新增或更新对账差异
+	Reconciliations(status string) ([]*Reconciliation, error)             // 按处理状态查询对账差异
+	AcquireLease(plan, owner string, now, expires uint64) (*Lease, error) // 获取或续期租约
+	TakeoverLease(plan, owner, previous string, expires uint64) error     // 接管租约
+	ReleaseLease(plan, owner string) error                                // 释放租约
+
+	Version() (int, error)          // 数据库当前版本
+	LatestVersion() int             // 程序支持的最新版本
+	Pending() ([]*Migration, error) // 尚未执行的迁移
+	Migrate() ([]*Migration, error) // 迁移到最新版本
+
+	Close() error // 关闭数据库
+}
+
+// Plan 定投计划表，订单及统计通过 plan_id 关联
+type Plan struct {
+	ID      uint64 // 编号
+	Name    string // 计划名称
+	Created uint64 // 创建时间
+}
 
 // Order 订单表
 type Order struct {
 	ID          uint64  // 订单号
+	PlanID      uint64  // 定投计划编号
+	Plan        string  // 定投计划
 	Symbol      string  // 交易品种
 	Type        string  // 交易类型
+	State       string  // 订单状态
+	Amount      float64 // 下单数量，市价买单为报价货币金额，其余为基础货币数量
 	Price       float64 // 成交价格
 	BaseAmount  float64 // 成交金额（基础货币）
 	QuoteAmount float64 // 花费金额（报价货币）
+	Fees        float64 // 手续费
+	FeeCurrency string  // 手续费币种
 	Created     uint64  // 创建时间
+	Finished    uint64  // 成交时间，未完成时为 0
 }
 
-const sqlOrder = `
-CREATE TABLE IF NOT EXISTS 'orders' (
-    'id'            INTEGER PRIMARY KEY,
-    'symbol'        TEXT NOT NULL,
-    'type'          TEXT NOT NULL,
-    'price'         REAL NOT NULL,
-    'base_amount'   REAL NOT NULL,
-    'quote_amount'  REAL NOT NULL,
-    'created'       TIMESTAMP default (datetime('now', 'localtime'))
-);
-`
-
 // Statistics 统计表
 type Statistics struct {
 	ID         uint64  // 编号
+	PlanID     uint64  // 定投计划编号
+	Plan       string  // 定投计划
 	Symbol     string  // 交易品种
 	Position   float64 // 持仓总额（基础货币）
 	Investment float64 // 投入总额（报价货币）
 	Price      float64 // 当前价格
 	Equity     float64 // 净值总额（报价货币）
-	Created    uint64  // 创建时间
+	Created    uint64  // 创建时间，按日汇总时为当日最后一条统计的时间
+	Daily      bool    // 是否为按日汇总的统计，汇总时 Price 及 Equity 为当日最后的值
+	PriceMin   float64 // 最低价格，未汇总时与 Price 相同
+	PriceMax   float64 // 最高价格，未汇总时与 Price 相同
+	EquityMin  float64 // 最低净值，未汇总时与 Equity 相同
+	EquityMax  float64 // 最高净值，未汇总时与 Equity 相同
+	Samples    int     // 汇总的统计条数，未汇总时为 1
 }
 
-const sqlStatistics = `
-CREATE TABLE IF NOT EXISTS 'statistics' (
-    'id'            INTEGER PRIMARY KEY,
-    'symbol'        TEXT NOT NULL,
-    'position'      REAL NOT NULL,
-    'investment'    REAL NOT NULL,
-    'price'         REAL NOT NULL,
-    'equity'        REAL NOT NULL,
-    'created'       TIMESTAMP default (datetime('now', 'localtime'))
-);
-`
-
-// Init 初始化 sqlite3 数据库
-func Init(path string) error {
-	var err error
-
-	db, err = sql.Open("sqlite3", path)
-	if err != nil {
-		return errors.Wrap(err, util.FuncName())
-	}
-
-	if _, err = db.Exec(sqlOrder); err != nil {
-		return errors.Wrap(err, util.FuncName())
-	}
+// Execution 执行记录表，每个计划执行时间一条
+type Execution struct {
+	ID        uint64 // 编号
+	Plan      string // 定投计划
+	Symbol    string // 交易品种
+	Scheduled uint64 // 计划执行时间
+	Status    string // 执行状态
+	OrderID   uint64 // 订单号
+	Error     string // 错误信息
+	Created   uint64 // 创建时间
+}
 
-	if _, err = db.Exec(sqlStatistics); err != nil {
-		return errors.Wrap(err, util.FuncName())
-	}
+// 执行状态
+const (
+	ExecutionSuccess = "success" // 执行成功
+	ExecutionFailed  = "failed"  // 下单前失败，之后按补投策略重试
+	ExecutionPlaced  = "placed"  // 已下单但未能确认或记录订单，不再重试，由对账补录
+	ExecutionMerged  = "merged"  // 合并到一笔补投订单中执行
+	ExecutionSkipped = "skipped" // 错过后跳过
+)
 
-	return nil
+// Reconciliation 对账差异表，同一类型、币种及订单的差异只记录一条
+type Reconciliation struct {
+	ID       uint64  // 编号
+	Kind     string  // 差异类型
+	Plan     string  // 定投计划，无法确定时为空
+	Symbol   string  // 交易品种，余额差异为空
+	Currency string  // 币种
+	OrderID  uint64  // 订单号，余额差异为 0
+	Expected float64 // 数据库中的数值：持仓或成交数量
+	Actual   float64 // 交易所中的数值：余额或成交数量
+	Status   string  // 处理状态
+	Detail   string  // 说明
+	Created  uint64  // 首次发现时间
+	Updated  uint64  // 最近发现时间
 }
 
-// AddOrder 新增订单
-func AddOrder(order *Order) error {
-	stmt, err := db.Prepare(`
-		INSERT INTO
-		orders(id, symbol, type, price, base_amount, quote_amount, created)
-		VALUES(?, ?, ?, ?, ?, ?, datetime(?, 'unixepoch', 'localtime'));`)
-	if err != nil {
-		return errors.Wrap(err, util.FuncName())
-	}
+// 对账差异类型
+const (
+	ReconcileMissing = "missing" // 交易所通过 API 成交、数据库中没有的订单
+	ReconcileState   = "state"   // 数据库中的订单状态与交易所不一致，如仍为未完成状态
+	ReconcileManual  = "manual"  // 非 API 下单的手动交易
+	ReconcileBalance = "balance" // 数据库计算的持仓与现货账户余额不一致
+)
 
-	if _, err = stmt.Exec(
-		order.ID,
-		order.Symbol,
-		order.Type,
-		order.Price,
-		order.BaseAmount,
-		order.QuoteAmount,
-		order.Created); err != nil {
-		return errors.Wrap(err, util.FuncName())
-	}
+// 对账差异处理状态
+const (
+	ReconcileOpen       = "open"       // 待处理
+	ReconcileBackfilled = "backfilled" // 已按交易所数据补录
+	ReconcileResolved   = "resolved"   // 之后的对账不再发现
+)
 
-	return nil
+// Lease 租约表，同一定投计划同时只允许一个实例调度
+type Lease struct {
+	Plan    string // 定投计划
+	Owner   string // 持有者，主机名/进程号
+	Expires uint64 // 过期时间
 }
 
-// AddStatistics 新增统计
-func AddStatistics(statistics *Statistics) error {
-	stmt, err := db.Prepare(`
-		INSERT INTO
-		statistics(symbol, position, investment, price, equity)
-		VALUES(?,?,?,?,?);`)
-	if err != nil {
-		return errors.Wrap(err, util.FuncName())
+// ErrLeaseHeld 租约被其他实例持有且未过期
+var ErrLeaseHeld = errors.New("lease is held by another instance")
+
+// Filter 订单及统计的查询条件，零值表示不限
+type Filter struct {
+	Plan   string // 定投计划
+	Symbol string // 交易品种
+	Since  uint64 // 创建时间不早于
+	Until  uint64 // 创建时间早于
+	Limit  int    // 最多返回条数
+}
+
+// Open 打开数据库，不执行迁移。
+// driver 为 sqlite3 时 source 为数据文件路径，为 postgres 时为连接地址
+func Open(driver, source string) (Store, error) {
+	var d dialect
+	switch driver {
+	case DriverSQLite:
+		d = sqlite{}
+	case DriverPostgres:
+		d = postgres{}
+	default:
+		return nil, errors.Wrap(errUnknownDriver, driver)
 	}
 
-	if _, err = stmt.Exec(
-		statistics.Symbol,
-		statistics.Position,
-		statistics.Investment,
-		statistics.Price,
-		statistics.Equity); err != nil {
-		return errors.Wrap(err, util.FuncName())
+	db, err := sql.Open(driver, source)
+	if err != nil {
+		return nil, errors.Wrap(err, util.FuncName())
 	}
 
-	return nil
+	return &store{db: db, dialect: d}, nil
 }
 
-// OrderSummary 返回订单汇总
-// 返回值 position   持仓总额（基础货币）
-// 返回值 investment 投入总额（报价货币）
-func OrderSummary() (position, investment float64, err error) {
-	row := db.QueryRow(`SELECT
-		SUM(base_amount) AS position,
-		SUM(quote_amount) AS investment FROM orders;`)
-	if err := row.Scan(&position, &investment); err != nil {
-		return 0, 0, errors.Wrap(err, util.FuncName())
+// Init 打开数据库并迁移到最新版本
+func Init(driver, source string) (Store, error) {
+	s, err := Open(driver, source)
+	if err != nil {
+		return nil, errors.Wrap(err, util.FuncName())
+	}
+
+	if _, err = s.Migrate(); err != nil {
+		if e := s.Close(); e != nil {
+			log.Println(e)
+		}
+		return nil, errors.Wrap(err, util.FuncName())
 	}
 
-	return position, investment, nil
+	return s, nil
 }
@@ -0,0 +1,92 @@
+package db
+
+import (
+	"log"
+
+	"github.com/modood/aip/util"
+
+	"github.com/pkg/errors"
+)
+
+// Migration 数据库迁移，按版本号顺序执行，每个迁移在一个事务中完成
+type Migration struct {
+	Version int    // 版本号，从 1 开始连续递增
+	Name    string // 说明
+	SQL     string // 迁移脚本
+}
+
+// LatestVersion 返回程序支持的最新数据库版本
+func (s *store) LatestVersion() int {
+	m := s.dialect.migrations()
+	return m[len(m)-1].Version
+}
+
+// Version 返回数据库当前版本，0 表示未迁移
+func (s *store) Version() (int, error) {
+	if err := s.dialect.baseline(s.db); err != nil {
+		return 0, errors.Wrap(err, util.FuncName())
+	}
+
+	var version int
+	if err := s.queryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_version;`).
+		Scan(&version); err != nil {
+		return 0, errors.Wrap(err, util.FuncName())
+	}
+
+	return version, nil
+}
+
+// Pending 返回尚未执行的迁移
+func (s *store) Pending() ([]*Migration, error) {
+	version, err := s.Version()
+	if err != nil {
+		return nil, errors.Wrap(err, util.FuncName())
+	}
+	if version > s.LatestVersion() {
+		return nil, errors.Errorf("database version %d is newer than %d supported by this binary",
+			version, s.LatestVersion())
+	}
+
+	return s.dialect.migrations()[version:], nil
+}
+
+// Migrate 将数据库迁移到最新版本，返回本次执行的迁移
+func (s *store) Migrate() ([]*Migration, error) {
+	pending, err := s.Pending()
+	if err != nil {
+		return nil, errors.Wrap(err, util.FuncName())
+	}
+
+	for _, m := range pending {
+		if err = s.apply(m); err != nil {
+			return nil, errors.Wrapf(err, "%s: migration %d %s", util.FuncName(), m.Version, m.Name)
+		}
+	}
+
+	return pending, nil
+}
+
+// apply 在一个事务中执行迁移并记录版本
+func (s *store) apply(m *Migration) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+
+	if _, err = tx.Exec(m.SQL); err == nil {
+		_, err = tx.Exec(s.dialect.bind(`INSERT INTO schema_version(version, name) VALUES(?, ?);`),
+			m.Version, m.Name)
+	}
+	if err != nil {
+		if e := tx.Rollback(); e != nil {
+			log.Println(e)
+		}
+		return errors.Wrap(err, util.FuncName())
+	}
+
+	if err = tx.Commit(); err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+
+	return nil
+}
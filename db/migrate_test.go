@@ -0,0 +1,108 @@
+package db
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// tempDB 打开一个新的临时 sqlite3 数据库，返回清理函数
+func tempDB() (*store, func(), error) {
+	path := filepath.Join(os.TempDir(), fmt.Sprintf("aip-migrate-%d.sqlite3", time.Now().UnixNano()))
+	s, err := Open(DriverSQLite, path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return s.(*store), func() {
+		s.Close()
+		os.Remove(path)
+	}, nil
+}
+
+func TestMigrate(t *testing.T) {
+	Convey("should migrate a new database to the latest version", t, func() {
+		s, cleanup, err := tempDB()
+		So(err, ShouldBeNil)
+		defer cleanup()
+
+		version, err := s.Version()
+		So(err, ShouldBeNil)
+		So(version, ShouldEqual, 0)
+
+		applied, err := s.Migrate()
+		So(err, ShouldBeNil)
+		So(len(applied), ShouldEqual, s.LatestVersion())
+
+		version, err = s.Version()
+		So(err, ShouldBeNil)
+		So(version, ShouldEqual, s.LatestVersion())
+
+		applied, err = s.Migrate()
+		So(err, ShouldBeNil)
+		So(len(applied), ShouldEqual, 0)
+	})
+
+	Convey("should continue from user_version of old databases", t, func() {
+		s, cleanup, err := tempDB()
+		So(err, ShouldBeNil)
+		defer cleanup()
+
+		_, err = s.db.Exec(sqlOrder + sqlStatistics + sqlExecution + `
+			INSERT INTO orders(id, symbol, type, price, base_amount, quote_amount, created)
+			VALUES(1, 'btcusdt', 'buy-market', 6400, 0.01, 64, '2018-09-08 12:00:00');
+			INSERT INTO orders(id, symbol, type, price, base_amount, quote_amount, created)
+			VALUES(2, 'btcusdt', 'sell-market', 7000, 0.005, 35, '2018-09-09 12:00:00');
+			PRAGMA user_version = 1;`)
+		So(err, ShouldBeNil)
+
+		version, err := s.Version()
+		So(err, ShouldBeNil)
+		So(version, ShouldEqual, 2)
+
+		applied, err := s.Migrate()
+		So(err, ShouldBeNil)
+		So(applied[0].Version, ShouldEqual, 3)
+
+		var (
+			plan, created string
+			planID        uint64
+		)
+		So(s.db.QueryRow(`SELECT plan, plan_id, created FROM orders WHERE id = 1;`).
+			Scan(&plan, &planID, &created), ShouldBeNil)
+		So(plan, ShouldEqual, "default")
+		So(planID, ShouldNotEqual, 0)
+		So(created, ShouldStartWith, "2018-09-08")
+		So(created, ShouldContainSubstring, "12:00:00")
+
+		var base, quote float64
+		So(s.db.QueryRow(`SELECT base_amount, quote_amount FROM orders WHERE id = 2;`).
+			Scan(&base, &quote), ShouldBeNil)
+		So(base, ShouldEqual, -0.005)
+		So(quote, ShouldEqual, -35)
+	})
+
+	Convey("should roll back a failed migration", t, func() {
+		s, cleanup, err := tempDB()
+		So(err, ShouldBeNil)
+		defer cleanup()
+
+		So(s.dialect.baseline(s.db), ShouldBeNil)
+		err = s.apply(&Migration{Version: 1, Name: "broken", SQL: `
+			CREATE TABLE broken (id INTEGER);
+			INSERT INTO nothing VALUES (1);`})
+		So(err, ShouldNotBeNil)
+
+		var n int
+		So(s.db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE name = 'broken';`).Scan(&n), ShouldBeNil)
+		So(n, ShouldEqual, 0)
+
+		version, err := s.Version()
+		So(err, ShouldBeNil)
+		So(version, ShouldEqual, 0)
+	})
+}
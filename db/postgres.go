@@ -0,0 +1,170 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/modood/aip/util"
+
+	_ "github.com/lib/pq" // justifying
+	"github.com/pkg/errors"
+)
+
+// postgresMigrations PostgreSQL 的全部迁移，只能在末尾追加，已发布的迁移不能修改
+var postgresMigrations = []*Migration{
+	{1, "create plans, orders, statistics, executions and leases", `
+CREATE TABLE IF NOT EXISTS plans (
+    id            BIGSERIAL PRIMARY KEY,
+    name          TEXT NOT NULL UNIQUE,
+    created       TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS orders (
+    id            BIGINT PRIMARY KEY,
+    plan_id       BIGINT NOT NULL REFERENCES plans(id),
+    plan          TEXT NOT NULL,
+    symbol        TEXT NOT NULL,
+    type          TEXT NOT NULL,
+    state         TEXT NOT NULL DEFAULT '',
+    amount        DOUBLE PRECISION NOT NULL DEFAULT 0,
+    price         DOUBLE PRECISION NOT NULL,
+    base_amount   DOUBLE PRECISION NOT NULL,
+    quote_amount  DOUBLE PRECISION NOT NULL,
+    fees          DOUBLE PRECISION NOT NULL DEFAULT 0,
+    fee_currency  TEXT NOT NULL DEFAULT '',
+    created       TIMESTAMPTZ NOT NULL DEFAULT now(),
+    finished      TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS orders_plan_symbol ON orders(plan_id, symbol, created);
+
+CREATE TABLE IF NOT EXISTS statistics (
+    id            BIGSERIAL PRIMARY KEY,
+    plan_id       BIGINT NOT NULL REFERENCES plans(id),
+    plan          TEXT NOT NULL,
+    symbol        TEXT NOT NULL,
+    position      DOUBLE PRECISION NOT NULL,
+    investment    DOUBLE PRECISION NOT NULL,
+    price         DOUBLE PRECISION NOT NULL,
+    equity        DOUBLE PRECISION NOT NULL,
+    created       TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS statistics_plan_symbol ON statistics(plan_id, symbol, created);
+
+CREATE TABLE IF NOT EXISTS executions (
+    id            BIGSERIAL PRIMARY KEY,
+    plan          TEXT NOT NULL,
+    symbol        TEXT NOT NULL,
+    scheduled     BIGINT NOT NULL,
+    status        TEXT NOT NULL,
+    order_id      BIGINT NOT NULL DEFAULT 0,
+    error         TEXT NOT NULL DEFAULT '',
+    created       TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS executions_plan_scheduled ON executions(plan, scheduled);
+
+CREATE TABLE IF NOT EXISTS leases (
+    plan          TEXT PRIMARY KEY,
+    owner         TEXT NOT NULL,
+    expires       BIGINT NOT NULL
+);
+`},
+	{2, "create reconciliations", `
+CREATE TABLE IF NOT EXISTS reconciliations (
+    id            BIGSERIAL PRIMARY KEY,
+    kind          TEXT NOT NULL,
+    plan          TEXT NOT NULL DEFAULT '',
+    symbol        TEXT NOT NULL DEFAULT '',
+    currency      TEXT NOT NULL,
+    order_id      BIGINT NOT NULL DEFAULT 0,
+    expected      DOUBLE PRECISION NOT NULL DEFAULT 0,
+    actual        DOUBLE PRECISION NOT NULL DEFAULT 0,
+    status        TEXT NOT NULL,
+    detail        TEXT NOT NULL DEFAULT '',
+    created       TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated       TIMESTAMPTZ NOT NULL DEFAULT now(),
+    UNIQUE (kind, currency, order_id)
+);
+`},
+	{3, "create statistics_daily", `
+CREATE TABLE IF NOT EXISTS statistics_daily (
+    id            BIGSERIAL PRIMARY KEY,
+    plan_id       BIGINT NOT NULL REFERENCES plans(id),
+    plan          TEXT NOT NULL,
+    symbol        TEXT NOT NULL,
+    day           TIMESTAMPTZ NOT NULL,
+    position      DOUBLE PRECISION NOT NULL,
+    investment    DOUBLE PRECISION NOT NULL,
+    price         DOUBLE PRECISION NOT NULL,
+    price_min     DOUBLE PRECISION NOT NULL,
+    price_max     DOUBLE PRECISION NOT NULL,
+    equity        DOUBLE PRECISION NOT NULL,
+    equity_min    DOUBLE PRECISION NOT NULL,
+    equity_max    DOUBLE PRECISION NOT NULL,
+    samples       INTEGER NOT NULL,
+    created       TIMESTAMPTZ NOT NULL,
+    UNIQUE (plan_id, symbol, day)
+);
+CREATE INDEX IF NOT EXISTS statistics_daily_plan_symbol ON statistics_daily(plan_id, symbol, created);
+`},
+	// 导入及对账补录的卖单曾以正数记录，统一为负数
+	{4, "record sells as negative amounts", `
+UPDATE orders SET base_amount = -base_amount, quote_amount = -quote_amount
+    WHERE type LIKE 'sell%' AND base_amount > 0;
+`},
+}
+
+const pgSchemaVersion = `
+CREATE TABLE IF NOT EXISTS schema_version (
+    version       INTEGER PRIMARY KEY,
+    name          TEXT NOT NULL,
+    applied       TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+`
+
+// postgres PostgreSQL 方言，时间以 TIMESTAMPTZ 存储
+type postgres struct{}
+
+// bind 将 ? 依次替换为 $1, $2 ...，语句中的字符串常量不能包含 ?
+func (postgres) bind(query string) string {
+	var (
+		b strings.Builder
+		n int
+	)
+
+	for _, c := range query {
+		if c == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+
+	return b.String()
+}
+
+func (postgres) fromUnix(arg string) string {
+	return fmt.Sprintf("to_timestamp(CAST(%s AS BIGINT))", arg)
+}
+
+func (postgres) toUnix(column string) string {
+	return fmt.Sprintf("CAST(EXTRACT(EPOCH FROM %s) AS BIGINT)", column)
+}
+
+func (postgres) insertIgnore(table, values string) string {
+	return fmt.Sprintf("INSERT INTO %s VALUES(%s) ON CONFLICT DO NOTHING;", table, values)
+}
+
+func (postgres) migrations() []*Migration {
+	return postgresMigrations
+}
+
+func (postgres) baseline(db *sql.DB) error {
+	if _, err := db.Exec(pgSchemaVersion); err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+
+	return nil
+}
@@ -0,0 +1,238 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+
+	"github.com/modood/aip/util"
+
+	_ "github.com/mattn/go-sqlite3" // justifying
+	"github.com/pkg/errors"
+)
+
+const sqlPlan = `
+CREATE TABLE IF NOT EXISTS 'plans' (
+    'id'            INTEGER PRIMARY KEY,
+    'name'          TEXT NOT NULL UNIQUE,
+    'created'       TIMESTAMP default (datetime('now'))
+);
+`
+
+const sqlOrder = `
+CREATE TABLE IF NOT EXISTS 'orders' (
+    'id'            INTEGER PRIMARY KEY,
+    'symbol'        TEXT NOT NULL,
+    'type'          TEXT NOT NULL,
+    'price'         REAL NOT NULL,
+    'base_amount'   REAL NOT NULL,
+    'quote_amount'  REAL NOT NULL,
+    'created'       TIMESTAMP default (datetime('now'))
+);
+`
+
+const sqlStatistics = `
+CREATE TABLE IF NOT EXISTS 'statistics' (
+    'id'            INTEGER PRIMARY KEY,
+    'symbol'        TEXT NOT NULL,
+    'position'      REAL NOT NULL,
+    'investment'    REAL NOT NULL,
+    'price'         REAL NOT NULL,
+    'equity'        REAL NOT NULL,
+    'created'       TIMESTAMP default (datetime('now'))
+);
+`
+
+const sqlExecution = `
+CREATE TABLE IF NOT EXISTS 'executions' (
+    'id'            INTEGER PRIMARY KEY,
+    'symbol'        TEXT NOT NULL,
+    'scheduled'     INTEGER NOT NULL,
+    'status'        TEXT NOT NULL,
+    'order_id'      INTEGER NOT NULL DEFAULT 0,
+    'error'         TEXT NOT NULL DEFAULT '',
+    'created'       TIMESTAMP default (datetime('now'))
+);
+`
+
+const sqlLease = `
+CREATE TABLE IF NOT EXISTS 'leases' (
+    'plan'          TEXT PRIMARY KEY,
+    'owner'         TEXT NOT NULL,
+    'expires'       INTEGER NOT NULL
+);
+`
+
+const sqlReconciliation = `
+CREATE TABLE IF NOT EXISTS 'reconciliations' (
+    'id'            INTEGER PRIMARY KEY,
+    'kind'          TEXT NOT NULL,
+    'plan'          TEXT NOT NULL DEFAULT '',
+    'symbol'        TEXT NOT NULL DEFAULT '',
+    'currency'      TEXT NOT NULL,
+    'order_id'      INTEGER NOT NULL DEFAULT 0,
+    'expected'      REAL NOT NULL DEFAULT 0,
+    'actual'        REAL NOT NULL DEFAULT 0,
+    'status'        TEXT NOT NULL,
+    'detail'        TEXT NOT NULL DEFAULT '',
+    'created'       TIMESTAMP default (datetime('now')),
+    'updated'       TIMESTAMP default (datetime('now')),
+    UNIQUE (kind, currency, order_id)
+);
+`
+
+// sqlStatisticsDaily 按日汇总的统计，价格及净值为当日最低、最高及最后的值，created 为当日最后一条统计的时间
+const sqlStatisticsDaily = `
+CREATE TABLE IF NOT EXISTS 'statistics_daily' (
+    'id'            INTEGER PRIMARY KEY,
+    'plan_id'       INTEGER NOT NULL,
+    'plan'          TEXT NOT NULL,
+    'symbol'        TEXT NOT NULL,
+    'day'           TIMESTAMP NOT NULL,
+    'position'      REAL NOT NULL,
+    'investment'    REAL NOT NULL,
+    'price'         REAL NOT NULL,
+    'price_min'     REAL NOT NULL,
+    'price_max'     REAL NOT NULL,
+    'equity'        REAL NOT NULL,
+    'equity_min'    REAL NOT NULL,
+    'equity_max'    REAL NOT NULL,
+    'samples'       INTEGER NOT NULL,
+    'created'       TIMESTAMP NOT NULL,
+    UNIQUE (plan_id, symbol, day)
+);
+CREATE INDEX IF NOT EXISTS statistics_daily_plan_symbol ON statistics_daily(plan_id, symbol, created);
+`
+
+// sqliteMigrations sqlite3 的全部迁移，只能在末尾追加，已发布的迁移不能修改
+var sqliteMigrations = []*Migration{
+	{1, "create orders, statistics and executions", sqlOrder + sqlStatistics + sqlExecution},
+	// 旧版本按 sqlite 所在系统时区写入时间，统一转换为 UTC
+	{2, "store timestamps in utc", `
+	UPDATE orders SET created = datetime(created, 'utc');
+	UPDATE statistics SET created = datetime(created, 'utc');
+	UPDATE executions SET created = datetime(created, 'utc');
+	`},
+	// 支持多个定投计划，已有数据归属于默认计划
+	{3, "add plan to orders, statistics and executions", `
+	ALTER TABLE orders ADD COLUMN plan TEXT NOT NULL DEFAULT 'default';
+	ALTER TABLE statistics ADD COLUMN plan TEXT NOT NULL DEFAULT 'default';
+	ALTER TABLE executions ADD COLUMN plan TEXT NOT NULL DEFAULT 'default';
+	`},
+	{4, "create leases", sqlLease},
+	// 记录手续费、订单状态及下单数量，已有订单的状态未知
+	{5, "add fees, state, amount and finished to orders", `
+	ALTER TABLE orders ADD COLUMN state TEXT NOT NULL DEFAULT '';
+	ALTER TABLE orders ADD COLUMN amount REAL NOT NULL DEFAULT 0;
+	ALTER TABLE orders ADD COLUMN fees REAL NOT NULL DEFAULT 0;
+	ALTER TABLE orders ADD COLUMN fee_currency TEXT NOT NULL DEFAULT '';
+	ALTER TABLE orders ADD COLUMN finished TIMESTAMP;
+	`},
+	// 订单及统计按计划编号和交易品种区分，已有数据按计划名称关联
+	{6, "add plans and scope orders and statistics by plan_id", sqlPlan + `
+	INSERT OR IGNORE INTO plans(name)
+		SELECT plan FROM orders UNION SELECT plan FROM statistics UNION SELECT plan FROM executions;
+	ALTER TABLE orders ADD COLUMN plan_id INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE statistics ADD COLUMN plan_id INTEGER NOT NULL DEFAULT 0;
+	UPDATE orders SET plan_id = (SELECT id FROM plans WHERE plans.name = orders.plan);
+	UPDATE statistics SET plan_id = (SELECT id FROM plans WHERE plans.name = statistics.plan);
+	CREATE INDEX IF NOT EXISTS orders_plan_symbol ON orders(plan_id, symbol, created);
+	CREATE INDEX IF NOT EXISTS statistics_plan_symbol ON statistics(plan_id, symbol, created);
+	`},
+	{7, "create reconciliations", sqlReconciliation},
+	{8, "create statistics_daily", sqlStatisticsDaily},
+	// 导入及对账补录的卖单曾以正数记录，统一为负数
+	{9, "record sells as negative amounts", `
+	UPDATE orders SET base_amount = -base_amount, quote_amount = -quote_amount
+	WHERE type LIKE 'sell%' AND base_amount > 0;
+	`},
+}
+
+const sqlSchemaVersion = `
+CREATE TABLE IF NOT EXISTS 'schema_version' (
+    'version'       INTEGER PRIMARY KEY,
+    'name'          TEXT NOT NULL,
+    'applied'       TIMESTAMP default (datetime('now'))
+);
+`
+
+// sqlite sqlite3 方言，时间以 UTC 文本存储
+type sqlite struct{}
+
+func (sqlite) bind(query string) string {
+	return query
+}
+
+func (sqlite) fromUnix(arg string) string {
+	return fmt.Sprintf("datetime(%s, 'unixepoch')", arg)
+}
+
+func (sqlite) toUnix(column string) string {
+	return fmt.Sprintf("CAST(strftime('%%s', %s) AS INTEGER)", column)
+}
+
+func (sqlite) insertIgnore(table, values string) string {
+	return fmt.Sprintf("INSERT OR IGNORE INTO %s VALUES(%s);", table, values)
+}
+
+func (sqlite) migrations() []*Migration {
+	return sqliteMigrations
+}
+
+// baseline 创建版本表。旧版本以 PRAGMA user_version 记录升级进度，
+// user_version 为 n 时表示建表及前 n 个升级脚本（即迁移 1 至 n+1）已执行
+func (sqlite) baseline(db *sql.DB) error {
+	var exists int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master
+		WHERE type = 'table' AND name = 'schema_version';`).Scan(&exists); err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+	if exists > 0 {
+		return nil
+	}
+
+	var version int
+	if err := db.QueryRow(`PRAGMA user_version;`).Scan(&version); err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+
+	if err = baselineTx(tx, version); err != nil {
+		if e := tx.Rollback(); e != nil {
+			log.Println(e)
+		}
+		return errors.Wrap(err, util.FuncName())
+	}
+
+	if err = tx.Commit(); err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+
+	return nil
+}
+
+func baselineTx(tx *sql.Tx, version int) error {
+	if _, err := tx.Exec(sqlSchemaVersion); err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+
+	if version == 0 {
+		return nil
+	}
+	if version >= len(sqliteMigrations) {
+		return errors.Errorf("unknown user_version %d", version)
+	}
+
+	for _, m := range sqliteMigrations[:version+1] {
+		if _, err := tx.Exec(`INSERT INTO schema_version(version, name) VALUES(?, ?);`,
+			m.Version, m.Name+" (user_version)"); err != nil {
+			return errors.Wrap(err, util.FuncName())
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,614 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/modood/aip/util"
+
+	"github.com/pkg/errors"
+)
+
+// dialect 数据库方言，处理不同数据库之间的 SQL 差异
+type dialect interface {
+	bind(query string) string                 // 将 ? 占位符替换为数据库的占位符
+	fromUnix(arg string) string               // unix 时间转换为时间戳的表达式
+	toUnix(column string) string              // 时间戳转换为 unix 时间的表达式
+	insertIgnore(table, values string) string // 插入语句，主键或唯一键冲突时忽略
+	migrations() []*Migration                 // 全部迁移
+	baseline(db *sql.DB) error                // 迁移前创建版本表
+}
+
+// store 基于 database/sql 的数据存储，SQL 差异由方言处理
+type store struct {
+	db      *sql.DB
+	dialect dialect
+}
+
+// exec 执行语句，占位符统一写作 ?
+func (s *store) exec(query string, args ...interface{}) (sql.Result, error) {
+	return s.db.Exec(s.dialect.bind(query), args...)
+}
+
+// query 查询多行，占位符统一写作 ?
+func (s *store) query(query string, args ...interface{}) (*sql.Rows, error) {
+	return s.db.Query(s.dialect.bind(query), args...)
+}
+
+// queryRow 查询单行，占位符统一写作 ?
+func (s *store) queryRow(query string, args ...interface{}) *sql.Row {
+	return s.db.QueryRow(s.dialect.bind(query), args...)
+}
+
+// unix 将 unix 时间转换为可写入的参数，0 表示空值
+func unix(t uint64) interface{} {
+	if t == 0 {
+		return nil
+	}
+	return t
+}
+
+// Close 关闭数据库
+func (s *store) Close() error {
+	if err := s.db.Close(); err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+
+	return nil
+}
+
+// AddPlan 新增定投计划，已存在时忽略，返回计划编号
+func (s *store) AddPlan(name string) (uint64, error) {
+	if _, err := s.exec(s.dialect.insertIgnore("plans(name)", "?"), name); err != nil {
+		return 0, errors.Wrap(err, util.FuncName())
+	}
+
+	var id uint64
+	if err := s.queryRow(`SELECT id FROM plans WHERE name = ?;`, name).Scan(&id); err != nil {
+		return 0, errors.Wrap(err, util.FuncName())
+	}
+
+	return id, nil
+}
+
+// Plans 返回全部定投计划
+func (s *store) Plans() ([]*Plan, error) {
+	rows, err := s.query(`SELECT
+		id, name, ` + s.dialect.toUnix("created") + `
+		FROM plans ORDER BY id;`)
+	if err != nil {
+		return nil, errors.Wrap(err, util.FuncName())
+	}
+	defer rows.Close()
+
+	var r []*Plan
+	for rows.Next() {
+		p := &Plan{}
+		if err = rows.Scan(&p.ID, &p.Name, &p.Created); err != nil {
+			return nil, errors.Wrap(err, util.FuncName())
+		}
+		r = append(r, p)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, util.FuncName())
+	}
+
+	return r, nil
+}
+
+// AddOrder 新增订单，计划需先通过 AddPlan 创建
+func (s *store) AddOrder(order *Order) error {
+	if _, err := s.exec(`
+		INSERT INTO
+		orders(id, plan_id, plan, symbol, type, state, amount, price, base_amount, quote_amount,
+			fees, fee_currency, created, finished)
+		VALUES(?, (SELECT id FROM plans WHERE name = ?), ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, `+
+		s.dialect.fromUnix("?")+`, `+s.dialect.fromUnix("?")+`);`,
+		order.ID,
+		order.Plan,
+		order.Plan,
+		order.Symbol,
+		order.Type,
+		order.State,
+		order.Amount,
+		order.Price,
+		order.BaseAmount,
+		order.QuoteAmount,
+		order.Fees,
+		order.FeeCurrency,
+		order.Created,
+		unix(order.Finished)); err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+
+	return nil
+}
+
+// SaveOrder 新增或更新订单，订单已存在时只更新成交信息，不改变所属计划。
+// 返回值 created 是否为新增的订单
+func (s *store) SaveOrder(order *Order) (created bool, err error) {
+	res, err := s.exec(`
+		UPDATE orders SET
+		type = ?, state = ?, amount = ?, price = ?, base_amount = ?, quote_amount = ?,
+		fees = ?, fee_currency = ?, finished = `+s.dialect.fromUnix("?")+`
+		WHERE id = ?;`,
+		order.Type,
+		order.State,
+		order.Amount,
+		order.Price,
+		order.BaseAmount,
+		order.QuoteAmount,
+		order.Fees,
+		order.FeeCurrency,
+		unix(order.Finished),
+		order.ID)
+	if err != nil {
+		return false, errors.Wrap(err, util.FuncName())
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return false, errors.Wrap(err, util.FuncName())
+	} else if n > 0 {
+		return false, nil
+	}
+
+	if err = s.AddOrder(order); err != nil {
+		return false, errors.Wrap(err, util.FuncName())
+	}
+
+	return true, nil
+}
+
+// AddStatistics 新增统计，未指定创建时间时为当前时间
+func (s *store) AddStatistics(statistics *Statistics) error {
+	if _, err := s.exec(`
+		INSERT INTO
+		statistics(plan_id, plan, symbol, position, investment, price, equity, created)
+		VALUES((SELECT id FROM plans WHERE name = ?),?,?,?,?,?,?,
+		COALESCE(`+s.dialect.fromUnix("?")+`, CURRENT_TIMESTAMP));`,
+		statistics.Plan,
+		statistics.Plan,
+		statistics.Symbol,
+		statistics.Position,
+		statistics.Investment,
+		statistics.Price,
+		statistics.Equity,
+		unix(statistics.Created)); err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+
+	return nil
+}
+
+// OrderSummary 返回定投计划在指定交易品种上扣除手续费后的订单汇总，
+// 火币买单的手续费以基础货币收取，卖单以报价货币收取
+// 返回值 position   持仓总额（基础货币）
+// 返回值 investment 投入总额（报价货币）
+func (s *store) OrderSummary(plan, symbol string) (position, investment float64, err error) {
+	row := s.queryRow(`SELECT
+		COALESCE(SUM(base_amount - CASE WHEN type LIKE 'buy%' THEN fees ELSE 0 END), 0) AS position,
+		COALESCE(SUM(quote_amount + CASE WHEN type LIKE 'sell%' THEN fees ELSE 0 END), 0) AS investment
+		FROM orders
+		WHERE plan_id = (SELECT id FROM plans WHERE name = ?) AND symbol = ?;`, plan, symbol)
+	if err := row.Scan(&position, &investment); err != nil {
+		return 0, 0, errors.Wrap(err, util.FuncName())
+	}
+
+	return position, investment, nil
+}
+
+// AddExecution 新增执行记录
+func (s *store) AddExecution(execution *Execution) error {
+	if _, err := s.exec(`
+		INSERT INTO
+		executions(plan, symbol, scheduled, status, order_id, error)
+		VALUES(?, ?, ?, ?, ?, ?);`,
+		execution.Plan,
+		execution.Symbol,
+		execution.Scheduled,
+		execution.Status,
+		execution.OrderID,
+		execution.Error); err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+
+	return nil
+}
+
+// LastExecution 返回定投计划最近一次非失败的执行记录，没有记录时返回 nil。
+// 已下单但未记录订单的执行不算失败，不会重复投资
+func (s *store) LastExecution(plan string) (*Execution, error) {
+	row := s.queryRow(`SELECT
+		id, plan, symbol, scheduled, status, order_id, error
+		FROM executions
+		WHERE plan = ? AND status != ?
+		ORDER BY scheduled DESC LIMIT 1;`, plan, ExecutionFailed)
+
+	e := &Execution{}
+	err := row.Scan(&e.ID, &e.Plan, &e.Symbol, &e.Scheduled, &e.Status, &e.OrderID, &e.Error)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, util.FuncName())
+	}
+
+	return e, nil
+}
+
+// where 返回查询条件语句及参数
+func (s *store) where(f *Filter) (string, []interface{}) {
+	var (
+		where []string
+		args  []interface{}
+	)
+
+	if f.Plan != "" {
+		where, args = append(where, "plan_id = (SELECT id FROM plans WHERE name = ?)"), append(args, f.Plan)
+	}
+	if f.Symbol != "" {
+		where, args = append(where, "symbol = ?"), append(args, f.Symbol)
+	}
+	if f.Since > 0 {
+		where, args = append(where, "created >= "+s.dialect.fromUnix("?")), append(args, f.Since)
+	}
+	if f.Until > 0 {
+		where, args = append(where, "created < "+s.dialect.fromUnix("?")), append(args, f.Until)
+	}
+
+	if len(where) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(where, " AND "), args
+}
+
+// limit 返回条数限制语句
+func limit(f *Filter) string {
+	if f.Limit > 0 {
+		return fmt.Sprintf(" LIMIT %d", f.Limit)
+	}
+	return ""
+}
+
+// Orders 按条件查询订单，按创建时间倒序
+func (s *store) Orders(f *Filter) ([]*Order, error) {
+	where, args := s.where(f)
+	rows, err := s.query(`SELECT
+		id, plan_id, plan, symbol, type, state, amount, price, base_amount, quote_amount,
+		fees, fee_currency, `+s.dialect.toUnix("created")+`,
+		COALESCE(`+s.dialect.toUnix("finished")+`, 0)
+		FROM orders`+where+" ORDER BY created DESC, id DESC"+limit(f)+";", args...)
+	if err != nil {
+		return nil, errors.Wrap(err, util.FuncName())
+	}
+	defer rows.Close()
+
+	var r []*Order
+	for rows.Next() {
+		o := &Order{}
+		if err = rows.Scan(&o.ID, &o.PlanID, &o.Plan, &o.Symbol, &o.Type, &o.State, &o.Amount, &o.Price,
+			&o.BaseAmount, &o.QuoteAmount, &o.Fees, &o.FeeCurrency, &o.Created, &o.Finished); err != nil {
+			return nil, errors.Wrap(err, util.FuncName())
+		}
+		r = append(r, o)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, util.FuncName())
+	}
+
+	return r, nil
+}
+
+// ListStatistics 按条件查询统计，按创建时间倒序。已汇总的日期返回按日汇总的统计，
+// 与未汇总的统计一起查询及排序
+func (s *store) ListStatistics(f *Filter) ([]*Statistics, error) {
+	where, args := s.where(f)
+	rows, err := s.query(`SELECT * FROM (
+		SELECT id, plan_id, plan, symbol, position, investment, price, equity,
+			price AS price_min, price AS price_max, equity AS equity_min, equity AS equity_max,
+			1 AS samples, 0 AS daily, `+s.dialect.toUnix("created")+` AS created_unix
+			FROM statistics`+where+`
+		UNION ALL
+		SELECT id, plan_id, plan, symbol, position, investment, price, equity,
+			price_min, price_max, equity_min, equity_max,
+			samples, 1, `+s.dialect.toUnix("created")+`
+			FROM statistics_daily`+where+`
+		) AS s ORDER BY created_unix DESC, id DESC`+limit(f)+";", append(args, args...)...)
+	if err != nil {
+		return nil, errors.Wrap(err, util.FuncName())
+	}
+	defer rows.Close()
+
+	var r []*Statistics
+	for rows.Next() {
+		st := &Statistics{}
+		var daily int
+		if err = rows.Scan(&st.ID, &st.PlanID, &st.Plan, &st.Symbol, &st.Position, &st.Investment,
+			&st.Price, &st.Equity, &st.PriceMin, &st.PriceMax, &st.EquityMin, &st.EquityMax,
+			&st.Samples, &daily, &st.Created); err != nil {
+			return nil, errors.Wrap(err, util.FuncName())
+		}
+		st.Daily = daily == 1
+		r = append(r, st)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, util.FuncName())
+	}
+
+	return r, nil
+}
+
+// dailyKey 按日汇总的统计的唯一键
+type dailyKey struct {
+	planID uint64
+	symbol string
+	day    uint64
+}
+
+// CompactStatistics 将 before 在 loc 时区当日零点之前的统计按计划、交易品种及 loc 时区的日期汇总，
+// 并删除已汇总的统计。已有汇总的日期合并计算，重复执行不影响结果。返回汇总的统计条数
+func (s *store) CompactStatistics(before uint64, loc *time.Location) (int, error) {
+	before = midnight(before, loc)
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, errors.Wrap(err, util.FuncName())
+	}
+
+	n, err := s.compact(tx, before, loc)
+	if err != nil {
+		if e := tx.Rollback(); e != nil {
+			log.Println(e)
+		}
+		return 0, errors.Wrap(err, util.FuncName())
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, errors.Wrap(err, util.FuncName())
+	}
+
+	return n, nil
+}
+
+// compact 在事务中汇总 before 之前的统计
+func (s *store) compact(tx *sql.Tx, before uint64, loc *time.Location) (int, error) {
+	rows, err := tx.Query(s.dialect.bind(`SELECT
+		id, plan_id, plan, symbol, position, investment, price, equity,
+		`+s.dialect.toUnix("created")+`
+		FROM statistics
+		WHERE created < `+s.dialect.fromUnix("?")+`
+		ORDER BY created, id;`), before)
+	if err != nil {
+		return 0, errors.Wrap(err, util.FuncName())
+	}
+
+	var (
+		n    int
+		keys []dailyKey
+		days = make(map[dailyKey]*Statistics)
+	)
+	for rows.Next() {
+		st := &Statistics{Samples: 1}
+		if err = rows.Scan(&st.ID, &st.PlanID, &st.Plan, &st.Symbol, &st.Position, &st.Investment,
+			&st.Price, &st.Equity, &st.Created); err != nil {
+			rows.Close()
+			return 0, errors.Wrap(err, util.FuncName())
+		}
+		st.PriceMin, st.PriceMax, st.EquityMin, st.EquityMax = st.Price, st.Price, st.Equity, st.Equity
+		n++
+
+		k := dailyKey{planID: st.PlanID, symbol: st.Symbol, day: midnight(st.Created, loc)}
+		if d, ok := days[k]; ok {
+			rollup(d, st)
+			continue
+		}
+		st.Daily = true
+		days[k] = st
+		keys = append(keys, k)
+	}
+	if err = rows.Err(); err != nil {
+		rows.Close()
+		return 0, errors.Wrap(err, util.FuncName())
+	}
+	rows.Close()
+
+	for _, k := range keys {
+		d := days[k]
+
+		old := &Statistics{}
+		err = tx.QueryRow(s.dialect.bind(`SELECT
+			position, investment, price, price_min, price_max, equity, equity_min, equity_max, samples,
+			`+s.dialect.toUnix("created")+`
+			FROM statistics_daily
+			WHERE plan_id = ? AND symbol = ? AND day = `+s.dialect.fromUnix("?")+`;`),
+			k.planID, k.symbol, k.day).Scan(&old.Position, &old.Investment, &old.Price, &old.PriceMin,
+			&old.PriceMax, &old.Equity, &old.EquityMin, &old.EquityMax, &old.Samples, &old.Created)
+		switch {
+		case err == sql.ErrNoRows:
+			_, err = tx.Exec(s.dialect.bind(`
+				INSERT INTO
+				statistics_daily(plan_id, plan, symbol, day, position, investment, price, price_min, price_max,
+					equity, equity_min, equity_max, samples, created)
+				VALUES(?, ?, ?, `+s.dialect.fromUnix("?")+`, ?, ?, ?, ?, ?, ?, ?, ?, ?, `+
+				s.dialect.fromUnix("?")+`);`),
+				k.planID, d.Plan, k.symbol, k.day, d.Position, d.Investment, d.Price, d.PriceMin, d.PriceMax,
+				d.Equity, d.EquityMin, d.EquityMax, d.Samples, d.Created)
+		case err == nil:
+			rollup(d, old)
+			_, err = tx.Exec(s.dialect.bind(`
+				UPDATE statistics_daily SET
+				position = ?, investment = ?, price = ?, price_min = ?, price_max = ?,
+				equity = ?, equity_min = ?, equity_max = ?, samples = ?, created = `+s.dialect.fromUnix("?")+`
+				WHERE plan_id = ? AND symbol = ? AND day = `+s.dialect.fromUnix("?")+`;`),
+				d.Position, d.Investment, d.Price, d.PriceMin, d.PriceMax,
+				d.Equity, d.EquityMin, d.EquityMax, d.Samples, d.Created,
+				k.planID, k.symbol, k.day)
+		}
+		if err != nil {
+			return 0, errors.Wrap(err, util.FuncName())
+		}
+	}
+
+	if _, err = tx.Exec(s.dialect.bind(`DELETE FROM statistics WHERE created < `+
+		s.dialect.fromUnix("?")+`;`), before); err != nil {
+		return 0, errors.Wrap(err, util.FuncName())
+	}
+
+	return n, nil
+}
+
+// midnight 返回时间戳 t 在 loc 时区当日零点的时间戳
+func midnight(t uint64, loc *time.Location) uint64 {
+	y, m, d := time.Unix(int64(t), 0).In(loc).Date()
+	return uint64(time.Date(y, m, d, 0, 0, 0, 0, loc).Unix())
+}
+
+// rollup 将统计合并到按日汇总的统计 d 中，持仓、投入、价格及净值取时间较晚的值
+func rollup(d, st *Statistics) {
+	d.PriceMin = math.Min(d.PriceMin, st.PriceMin)
+	d.PriceMax = math.Max(d.PriceMax, st.PriceMax)
+	d.EquityMin = math.Min(d.EquityMin, st.EquityMin)
+	d.EquityMax = math.Max(d.EquityMax, st.EquityMax)
+	d.Samples += st.Samples
+
+	if st.Created >= d.Created {
+		d.Position, d.Investment, d.Price, d.Equity, d.Created =
+			st.Position, st.Investment, st.Price, st.Equity, st.Created
+	}
+}
+
+// SaveReconciliation 新增或更新对账差异，已记录的差异更新数值、状态及最近发现时间
+func (s *store) SaveReconciliation(r *Reconciliation) error {
+	res, err := s.exec(`
+		UPDATE reconciliations SET
+		plan = ?, symbol = ?, expected = ?, actual = ?, status = ?, detail = ?, updated = CURRENT_TIMESTAMP
+		WHERE kind = ? AND currency = ? AND order_id = ?;`,
+		r.Plan, r.Symbol, r.Expected, r.Actual, r.Status, r.Detail,
+		r.Kind, r.Currency, r.OrderID)
+	if err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return errors.Wrap(err, util.FuncName())
+	} else if n > 0 {
+		return nil
+	}
+
+	if _, err = s.exec(`
+		INSERT INTO
+		reconciliations(kind, plan, symbol, currency, order_id, expected, actual, status, detail)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?);`,
+		r.Kind, r.Plan, r.Symbol, r.Currency, r.OrderID, r.Expected, r.Actual, r.Status,
+		r.Detail); err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+
+	return nil
+}
+
+// Reconciliations 按处理状态查询对账差异，状态为空时不限，按最近发现时间倒序
+func (s *store) Reconciliations(status string) ([]*Reconciliation, error) {
+	var (
+		where string
+		args  []interface{}
+	)
+	if status != "" {
+		where, args = " WHERE status = ?", append(args, status)
+	}
+
+	rows, err := s.query(`SELECT
+		id, kind, plan, symbol, currency, order_id, expected, actual, status, detail,
+		`+s.dialect.toUnix("created")+`, `+s.dialect.toUnix("updated")+`
+		FROM reconciliations`+where+" ORDER BY updated DESC, id DESC;", args...)
+	if err != nil {
+		return nil, errors.Wrap(err, util.FuncName())
+	}
+	defer rows.Close()
+
+	var r []*Reconciliation
+	for rows.Next() {
+		rc := &Reconciliation{}
+		if err = rows.Scan(&rc.ID, &rc.Kind, &rc.Plan, &rc.Symbol, &rc.Currency, &rc.OrderID,
+			&rc.Expected, &rc.Actual, &rc.Status, &rc.Detail, &rc.Created, &rc.Updated); err != nil {
+			return nil, errors.Wrap(err, util.FuncName())
+		}
+		r = append(r, rc)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, util.FuncName())
+	}
+
+	return r, nil
+}
+
+// AcquireLease 获取或续期定投计划的租约，租约已过期时直接接管。
+// 租约被其他实例持有时返回当前租约及 ErrLeaseHeld
+func (s *store) AcquireLease(plan, owner string, now, expires uint64) (*Lease, error) {
+	res, err := s.exec(`UPDATE leases SET owner = ?, expires = ?
+		WHERE plan = ? AND (owner = ? OR expires < ?);`,
+		owner, expires, plan, owner, now)
+	if err != nil {
+		return nil, errors.Wrap(err, util.FuncName())
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, errors.Wrap(err, util.FuncName())
+	} else if n > 0 {
+		return &Lease{Plan: plan, Owner: owner, Expires: expires}, nil
+	}
+
+	res, err = s.exec(s.dialect.insertIgnore("leases(plan, owner, expires)", "?, ?, ?"),
+		plan, owner, expires)
+	if err != nil {
+		return nil, errors.Wrap(err, util.FuncName())
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, errors.Wrap(err, util.FuncName())
+	} else if n > 0 {
+		return &Lease{Plan: plan, Owner: owner, Expires: expires}, nil
+	}
+
+	l := &Lease{}
+	if err = s.queryRow(`SELECT plan, owner, expires FROM leases WHERE plan = ?;`, plan).
+		Scan(&l.Plan, &l.Owner, &l.Expires); err != nil {
+		return nil, errors.Wrap(err, util.FuncName())
+	}
+
+	return l, errors.Wrap(ErrLeaseHeld, util.FuncName())
+}
+
+// TakeoverLease 接管指定持有者的租约，用于持有者已确认退出的情况
+func (s *store) TakeoverLease(plan, owner, previous string, expires uint64) error {
+	res, err := s.exec(`UPDATE leases SET owner = ?, expires = ? WHERE plan = ? AND owner = ?;`,
+		owner, expires, plan, previous)
+	if err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+	if n == 0 {
+		return errors.Wrap(ErrLeaseHeld, util.FuncName())
+	}
+
+	return nil
+}
+
+// ReleaseLease 释放持有的租约，plan 为空时释放该持有者的全部租约
+func (s *store) ReleaseLease(plan, owner string) error {
+	var err error
+	if plan == "" {
+		_, err = s.exec(`DELETE FROM leases WHERE owner = ?;`, owner)
+	} else {
+		_, err = s.exec(`DELETE FROM leases WHERE plan = ? AND owner = ?;`, plan, owner)
+	}
+	if err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+
+	return nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/modood/aip/db"
+	"github.com/modood/aip/util"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var (
+	errNoDatabase  = errors.New("dbfile or dburl is required")
+	errNoRetention = errors.New("statistics-retention is required")
+)
+
+var dbCmd = &cobra.Command{
+	Use:   "db",
+	Short: "manage the database schema",
+}
+
+var dbMigrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "migrate the database to the latest version",
+	Args:  cobra.NoArgs,
+	RunE:  dbMigrate,
+}
+
+var dbVersionCmd = &cobra.Command{
+	Use:   "version",
+	Short: "print the database version and pending migrations",
+	Args:  cobra.NoArgs,
+	RunE:  dbVersion,
+}
+
+var dbCompactCmd = &cobra.Command{
+	Use:   "compact",
+	Short: "roll up hourly statistics older than statistics-retention days to daily ones",
+	Args:  cobra.NoArgs,
+	RunE:  dbCompact,
+}
+
+func init() {
+	dbCmd.AddCommand(dbMigrateCmd, dbVersionCmd, dbCompactCmd)
+}
+
+// openDB 打开配置的数据库，不执行迁移
+func openDB() (db.Store, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, errors.Wrap(err, util.FuncName())
+	}
+	if cfg.DBFile == "" && cfg.DBURL == "" {
+		return nil, errors.Wrap(errNoDatabase, util.FuncName())
+	}
+
+	s, err := db.Open(cfg.Database())
+	if err != nil {
+		return nil, errors.Wrap(err, util.FuncName())
+	}
+
+	return s, nil
+}
+
+func dbMigrate(cmd *cobra.Command, args []string) error {
+	s, err := openDB()
+	if err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+	defer s.Close()
+
+	applied, err := s.Migrate()
+	if err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+
+	for _, m := range applied {
+		fmt.Printf("applied %d: %s\n", m.Version, m.Name)
+	}
+	fmt.Printf("database is at version %d\n", s.LatestVersion())
+
+	return nil
+}
+
+func dbVersion(cmd *cobra.Command, args []string) error {
+	s, err := openDB()
+	if err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+	defer s.Close()
+
+	version, err := s.Version()
+	if err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+
+	fmt.Printf("version: %d\n", version)
+	fmt.Printf("latest:  %d\n", s.LatestVersion())
+
+	pending, err := s.Pending()
+	if err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+	for _, m := range pending {
+		fmt.Printf("pending %d: %s\n", m.Version, m.Name)
+	}
+
+	return nil
+}
+
+func dbCompact(cmd *cobra.Command, args []string) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+	if cfg.StatisticsRetention <= 0 {
+		return errors.Wrap(errNoRetention, util.FuncName())
+	}
+	if cfg.DBFile == "" && cfg.DBURL == "" {
+		return errors.Wrap(errNoDatabase, util.FuncName())
+	}
+
+	// 汇总表由迁移创建
+	s, err := db.Init(cfg.Database())
+	if err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+	defer s.Close()
+
+	loc, err := cfg.Location()
+	if err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+
+	n, before, err := compact(s, cfg.StatisticsRetention, time.Now().In(loc))
+	if err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+
+	fmt.Printf("rolled up %d statistics before %s to daily ones\n", n, before.Format(dateLayout))
+
+	return nil
+}
+
+// compact 将 retention 天之前的统计按 now 所在时区的日期汇总，返回汇总的条数及截止时间（当地零点）
+func compact(s db.Store, retention int, now time.Time) (int, time.Time, error) {
+	y, m, d := now.AddDate(0, 0, -retention).Date()
+	before := time.Date(y, m, d, 0, 0, 0, 0, now.Location())
+
+	n, err := s.CompactStatistics(uint64(before.Unix()), now.Location())
+	if err != nil {
+		return 0, before, errors.Wrap(err, util.FuncName())
+	}
+
+	return n, before, nil
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/modood/aip/config"
+	"github.com/modood/aip/db"
+	"github.com/modood/aip/huobi"
+	"github.com/modood/aip/util"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// 导出格式
+const (
+	formatCSV   = "csv"   // RFC 4180 CSV，时间为 RFC 3339
+	formatJSONL = "jsonl" // JSON Lines，每行一个对象，数字不加引号
+	formatExcel = "excel" // 可由 Excel 直接打开的 CSV：UTF-8 BOM、CRLF 换行、时间不带时区
+)
+
+var (
+	errUnknownFormat = errors.New("unknown format, available: csv, jsonl and excel")
+	errUnknownTable  = errors.New("unknown table, available: orders and statistics")
+)
+
+// 导出的列名，只能追加，不能修改
+var (
+	orderColumns = []string{"id", "time", "finished", "plan", "symbol", "type", "state",
+		"price", "amount", "base_amount", "quote_amount", "fees", "fee_currency"}
+	statisticsColumns = []string{"time", "plan", "symbol", "position", "investment", "price", "equity"}
+)
+
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "export orders or statistics as csv, json lines or excel compatible csv",
+	Long: `Export orders or statistics in chronological order. Prices, amounts and values are
+formatted with the precision of the symbol on the exchange.`,
+	Args: cobra.NoArgs,
+	RunE: export,
+}
+
+func init() {
+	exportCmd.Flags().String("table", "orders", "data to export, orders or statistics")
+	exportCmd.Flags().String("format", formatCSV, "output format: csv, jsonl, or excel for csv that excel opens directly")
+	exportCmd.Flags().StringP("output", "o", "", "file to write to, defaults to stdout")
+	exportCmd.Flags().String("plan", "", "only export data of the plan with this name")
+	exportCmd.Flags().String("only-symbol", "", "only export data of this symbol")
+	exportCmd.Flags().String("since", "", "only export data created on or after this date, yyyy-mm-dd")
+	exportCmd.Flags().String("until", "", "only export data created before this date, yyyy-mm-dd")
+}
+
+func export(cmd *cobra.Command, args []string) error {
+	var (
+		f   = &db.Filter{}
+		x   = &exporter{}
+		err error
+	)
+
+	flags := cmd.Flags()
+	table, err := flags.GetString("table")
+	if err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+	if table != "orders" && table != "statistics" {
+		return errors.Wrap(errUnknownTable, table)
+	}
+	if x.format, err = formatFlag(cmd); err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+	output, err := flags.GetString("output")
+	if err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+	if f.Plan, err = flags.GetString("plan"); err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+	if f.Symbol, err = flags.GetString("only-symbol"); err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+
+	e, err := setup()
+	if err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+	x.loc = e.loc
+	x.market = e.client
+
+	// 日期按配置的时区解析
+	if f.Since, err = dateFlag(cmd, "since", e.loc); err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+	if f.Until, err = dateFlag(cmd, "until", e.loc); err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+
+	w, closer, err := create(output)
+	if err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+	defer closer()
+	x.w = w
+
+	if table == "statistics" {
+		stats, err := e.store.ListStatistics(f)
+		if err != nil {
+			return errors.Wrap(err, util.FuncName())
+		}
+		if err = x.statistics(stats); err != nil {
+			return errors.Wrap(err, util.FuncName())
+		}
+		return nil
+	}
+
+	orders, err := e.store.Orders(f)
+	if err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+	if err = x.orders(orders); err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+
+	return nil
+}
+
+// formatFlag 返回并校验 --format 参数
+func formatFlag(cmd *cobra.Command) (string, error) {
+	format, err := cmd.Flags().GetString("format")
+	if err != nil {
+		return "", err
+	}
+	if format != formatCSV && format != formatJSONL && format != formatExcel {
+		return "", errors.Wrap(errUnknownFormat, format)
+	}
+	return format, nil
+}
+
+// create 创建输出文件，路径为空时输出到标准输出。返回的 closer 用于关闭文件
+func create(path string) (io.Writer, func(), error) {
+	if path == "" {
+		return os.Stdout, func() {}, nil
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, util.FuncName())
+	}
+
+	return file, func() {
+		if err := file.Close(); err != nil {
+			log.Println(err)
+		}
+	}, nil
+}
+
+// cell 导出的单元格，数字在 JSON Lines 中不加引号
+type cell struct {
+	text   string
+	number bool
+}
+
+// exporter 按格式导出订单及统计
+type exporter struct {
+	w      io.Writer
+	format string
+	loc    *time.Location
+	market config.Market // 查询交易品种的精度
+}
+
+// orders 按时间顺序导出订单，数据库按时间倒序返回
+func (x *exporter) orders(orders []*db.Order) error {
+	rows := make([][]cell, 0, len(orders))
+	for i := len(orders) - 1; i >= 0; i-- {
+		o := orders[i]
+		price, amount, value := x.precision(o.Symbol)
+
+		// 市价买单的下单数量为报价货币金额，手续费按收取的币种
+		requested, fees := amount, amount
+		if o.Type == string(huobi.BuyMarket) {
+			requested = value
+		}
+		if o.FeeCurrency != "" && o.FeeCurrency != x.base(o.Symbol) {
+			fees = value
+		}
+
+		rows = append(rows, []cell{
+			{text: strconv.FormatUint(o.ID, 10), number: true},
+			x.time(o.Created),
+			x.time(o.Finished),
+			{text: o.Plan},
+			{text: o.Symbol},
+			{text: o.Type},
+			{text: o.State},
+			decimal(o.Price, price),
+			decimal(o.Amount, requested),
+			decimal(o.BaseAmount, amount),
+			decimal(o.QuoteAmount, value),
+			decimal(o.Fees, fees),
+			{text: o.FeeCurrency},
+		})
+	}
+
+	return x.write(orderColumns, rows)
+}
+
+// statistics 按时间顺序导出统计
+func (x *exporter) statistics(stats []*db.Statistics) error {
+	rows := make([][]cell, 0, len(stats))
+	for i := len(stats) - 1; i >= 0; i-- {
+		st := stats[i]
+		price, amount, value := x.precision(st.Symbol)
+
+		rows = append(rows, []cell{
+			x.time(st.Created),
+			{text: st.Plan},
+			{text: st.Symbol},
+			decimal(st.Position, amount),
+			decimal(st.Investment, value),
+			decimal(st.Price, price),
+			decimal(st.Equity, value),
+		})
+	}
+
+	return x.write(statisticsColumns, rows)
+}
+
+// write 按格式写入表头及数据
+func (x *exporter) write(columns []string, rows [][]cell) error {
+	if x.format == formatJSONL {
+		return x.jsonl(columns, rows)
+	}
+
+	if x.format == formatExcel {
+		// Excel 依靠 BOM 识别 UTF-8
+		if _, err := io.WriteString(x.w, "\ufeff"); err != nil {
+			return errors.Wrap(err, util.FuncName())
+		}
+	}
+
+	w := csv.NewWriter(x.w)
+	w.UseCRLF = x.format == formatExcel
+	if err := w.Write(columns); err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+
+	record := make([]string, len(columns))
+	for _, row := range rows {
+		for i, c := range row {
+			record[i] = c.text
+		}
+		if err := w.Write(record); err != nil {
+			return errors.Wrap(err, util.FuncName())
+		}
+	}
+
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+
+	return nil
+}
+
+// jsonl 每行写入一个对象，键的顺序与列的顺序一致
+func (x *exporter) jsonl(columns []string, rows [][]cell) error {
+	w := bufio.NewWriter(x.w)
+	for _, row := range rows {
+		w.WriteByte('{')
+		for i, c := range row {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			key, err := json.Marshal(columns[i])
+			if err != nil {
+				return errors.Wrap(err, util.FuncName())
+			}
+			value := []byte(c.text)
+			if !c.number {
+				if value, err = json.Marshal(c.text); err != nil {
+					return errors.Wrap(err, util.FuncName())
+				}
+			}
+			w.Write(key)
+			w.WriteByte(':')
+			w.Write(value)
+		}
+		w.WriteString("}\n")
+	}
+
+	if err := w.Flush(); err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+
+	return nil
+}
+
+// time 格式化 unix 时间，0 表示空值
+func (x *exporter) time(t uint64) cell {
+	if t == 0 {
+		return cell{}
+	}
+
+	layout := time.RFC3339
+	if x.format == formatExcel {
+		layout = "2006-01-02 15:04:05"
+	}
+	return cell{text: time.Unix(int64(t), 0).In(x.loc).Format(layout)}
+}
+
+// precision 返回交易品种的价格、数量及金额精度，交易品种不存在时不限精度
+func (x *exporter) precision(symbol string) (price, amount, value int) {
+	s, err := x.market.Symbol(symbol)
+	if err != nil {
+		return -1, -1, -1
+	}
+	return s.PricePrecision, s.AmountPrecision, s.ValuePrecision
+}
+
+// base 返回交易品种的基础货币，交易品种不存在时为空
+func (x *exporter) base(symbol string) string {
+	s, err := x.market.Symbol(symbol)
+	if err != nil {
+		return ""
+	}
+	return s.BaseCurrency
+}
+
+// decimal 按精度格式化数字，精度为 -1 时使用最短的表示
+func decimal(v float64, prec int) cell {
+	return cell{text: strconv.FormatFloat(v, 'f', prec, 64), number: true}
+}
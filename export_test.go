@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/modood/aip/db"
+	"github.com/modood/aip/huobi"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type symbols map[string]*huobi.Symbol
+
+func (m symbols) Symbol(name string) (*huobi.Symbol, error) {
+	if s, ok := m[name]; ok {
+		return s, nil
+	}
+	return nil, errors.New("symbol not found")
+}
+
+func TestExport(t *testing.T) {
+	market := symbols{"btcusdt": {Symbol: "btcusdt", BaseCurrency: "btc", QuoteCurrency: "usdt",
+		PricePrecision: 2, AmountPrecision: 6, ValuePrecision: 8}}
+
+	// 数据库按时间倒序返回
+	orders := []*db.Order{
+		{ID: 2, Plan: "default", Symbol: "btcusdt", Type: "sell-market", State: "filled", Amount: 0.01,
+			Price: 6500.123, BaseAmount: -0.01, QuoteAmount: -65.00123, Fees: 0.13000246, FeeCurrency: "usdt",
+			Created: 1536463245, Finished: 1536463246},
+		{ID: 1, Plan: "default", Symbol: "btcusdt", Type: "buy-market", State: "filled", Amount: 10,
+			Price: 6412.825651302605, BaseAmount: 0.001559375, QuoteAmount: 10, Fees: 0.000003125,
+			FeeCurrency: "btc", Created: 1536376845},
+	}
+
+	Convey("should export orders as csv in chronological order", t, func() {
+		var b bytes.Buffer
+		x := &exporter{w: &b, format: formatCSV, loc: time.UTC, market: market}
+		So(x.orders(orders), ShouldBeNil)
+		So(b.String(), ShouldEqual, ""+
+			"id,time,finished,plan,symbol,type,state,price,amount,base_amount,quote_amount,fees,fee_currency\n"+
+			"1,2018-09-08T03:20:45Z,,default,btcusdt,buy-market,filled,6412.83,10.00000000,0.001559,10.00000000,0.000003,btc\n"+
+			"2,2018-09-09T03:20:45Z,2018-09-09T03:20:46Z,default,btcusdt,sell-market,filled,6500.12,0.010000,-0.010000,-65.00123000,0.13000246,usdt\n")
+	})
+
+	Convey("should export orders as json lines with numbers unquoted", t, func() {
+		var b bytes.Buffer
+		x := &exporter{w: &b, format: formatJSONL, loc: time.UTC, market: market}
+		So(x.orders(orders[1:]), ShouldBeNil)
+		So(b.String(), ShouldEqual, `{"id":1,"time":"2018-09-08T03:20:45Z","finished":"","plan":"default",`+
+			`"symbol":"btcusdt","type":"buy-market","state":"filled","price":6412.83,"amount":10.00000000,`+
+			`"base_amount":0.001559,"quote_amount":10.00000000,"fees":0.000003,"fee_currency":"btc"}`+"\n")
+	})
+
+	Convey("should export statistics as excel compatible csv", t, func() {
+		var b bytes.Buffer
+		x := &exporter{w: &b, format: formatExcel, loc: time.FixedZone("CST", 8*3600), market: market}
+		So(x.statistics([]*db.Statistics{{Plan: "default", Symbol: "dogeusdt", Position: 100.5,
+			Investment: 10, Price: 0.0995, Equity: 9.99975, Created: 1536376845}}), ShouldBeNil)
+		So(b.String(), ShouldEqual, "\ufeff"+
+			"time,plan,symbol,position,investment,price,equity\r\n"+
+			"2018-09-08 11:20:45,default,dogeusdt,100.5,10,0.0995,9.99975\r\n")
+	})
+}
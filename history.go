@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/modood/aip/db"
+	"github.com/modood/aip/util"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// dateLayout 命令行日期参数格式
+const dateLayout = "2006-01-02"
+
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "list the orders placed by the plans",
+	Args:  cobra.NoArgs,
+	RunE:  history,
+}
+
+func init() {
+	historyCmd.Flags().String("plan", "", "only list orders of the plan with this name")
+	historyCmd.Flags().String("only-symbol", "", "only list orders of this symbol")
+	historyCmd.Flags().String("since", "", "only list orders placed on or after this date, yyyy-mm-dd")
+	historyCmd.Flags().String("until", "", "only list orders placed before this date, yyyy-mm-dd")
+	historyCmd.Flags().IntP("limit", "n", 20, "maximum number of orders to list, 0 for all")
+}
+
+func history(cmd *cobra.Command, args []string) error {
+	var (
+		f   = &db.Filter{}
+		err error
+	)
+
+	flags := cmd.Flags()
+	if f.Plan, err = flags.GetString("plan"); err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+	if f.Symbol, err = flags.GetString("only-symbol"); err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+	if f.Limit, err = flags.GetInt("limit"); err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+
+	e, err := setup()
+	if err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+
+	// 日期按配置的时区解析
+	if f.Since, err = dateFlag(cmd, "since", e.loc); err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+	if f.Until, err = dateFlag(cmd, "until", e.loc); err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+
+	orders, err := e.store.Orders(f)
+	if err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tPLAN\tSYMBOL\tTYPE\tSTATE\tPRICE\tAMOUNT\tCOST\tFEE\tID")
+	for _, o := range orders {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%v\t%v\t%v\t%v %s\t%d\n",
+			time.Unix(int64(o.Created), 0).In(e.loc).Format("2006-01-02 15:04:05"),
+			o.Plan, o.Symbol, o.Type, o.State, o.Price, o.BaseAmount, o.QuoteAmount,
+			o.Fees, o.FeeCurrency, o.ID)
+	}
+	if err = w.Flush(); err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+
+	return nil
+}
+
+// dateFlag 解析日期参数，返回当天零点的 unix 时间，未指定时返回 0
+func dateFlag(cmd *cobra.Command, name string, loc *time.Location) (uint64, error) {
+	s, err := cmd.Flags().GetString(name)
+	if err != nil || s == "" {
+		return 0, err
+	}
+
+	t, err := time.ParseInLocation(dateLayout, s, loc)
+	if err != nil {
+		return 0, errors.Wrap(err, name)
+	}
+
+	return uint64(t.Unix()), nil
+}
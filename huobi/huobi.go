@@ -18,6 +18,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/modood/aip/metrics"
 	"github.com/modood/aip/util"
 
 	jsoniter "github.com/json-iterator/go"
@@ -32,6 +33,9 @@ var (
 	errUnkownTradeType   = errors.New("unknown trade type")
 
 	json = jsoniter.ConfigCompatibleWithStandardLibrary
+
+	requestDuration = metrics.NewHistogram("aip_exchange_request_duration_seconds",
+		"Latency of exchange API requests, including retries.", nil, "exchange", "endpoint")
 )
 
 // TradeType 交易类型
@@ -70,11 +74,13 @@ type Account struct {
 // Symbol 交易品种
 type Symbol struct {
 	Symbol          string
-	BaseCurrency    string `mapstructure:"base-currency" json:"base-currency"`
-	QuoteCurrency   string `mapstructure:"quote-currency" json:"quote-currency"`
-	PricePrecision  int    `mapstructure:"price-precision" json:"price-precision"`
-	AmountPrecision int    `mapstructure:"amount-precision" json:"amount-precision"`
-	SymbolPartition string `mapstructure:"symbol-partition" json:"symbol-partition"`
+	BaseCurrency    string  `mapstructure:"base-currency" json:"base-currency"`
+	QuoteCurrency   string  `mapstructure:"quote-currency" json:"quote-currency"`
+	PricePrecision  int     `mapstructure:"price-precision" json:"price-precision"`
+	AmountPrecision int     `mapstructure:"amount-precision" json:"amount-precision"`
+	ValuePrecision  int     `mapstructure:"value-precision" json:"value-precision"` // 成交金额精度
+	SymbolPartition string  `mapstructure:"symbol-partition" json:"symbol-partition"`
+	MinOrderValue   float64 `mapstructure:"min-order-value" json:"min-order-value"`
 }
 
 // OpenOrder 订单（状态可能未完成）
@@ -95,6 +101,67 @@ type OpenOrder struct {
 	CanceledAt      uint64  `mapstructure:"canceled-at" json:"canceled-at"`
 }
 
+// MatchResult 成交明细，一笔订单可能分多次成交
+type MatchResult struct {
+	ID           uint64
+	OrderID      uint64 `mapstructure:"order-id" json:"order-id"`
+	MatchID      uint64 `mapstructure:"match-id" json:"match-id"`
+	Symbol       string
+	Type         string
+	Source       string
+	Price        float64
+	FilledAmount float64 `mapstructure:"filled-amount" json:"filled-amount"`
+	FilledFees   float64 `mapstructure:"filled-fees" json:"filled-fees"`
+	CreatedAt    uint64  `mapstructure:"created-at" json:"created-at"`
+}
+
+// Kline K 线，编号为开始时间（unix 秒）
+type Kline struct {
+	ID     uint64
+	Open   float64
+	Close  float64
+	Low    float64
+	High   float64
+	Amount float64 // 成交量（基础货币）
+	Vol    float64 // 成交额（报价货币）
+	Count  uint64  // 成交笔数
+}
+
+// KlineLimit 单次查询 K 线的最大条数
+const KlineLimit = 2000
+
+// OrderQuery 历史订单及成交明细的查询条件，结果按编号倒序
+type OrderQuery struct {
+	Symbol string    // 交易品种
+	States string    // 订单状态，多个以逗号分隔，仅用于查询订单
+	Start  time.Time // 开始日期
+	End    time.Time // 结束日期（包含当天）
+	From   uint64    // 从该编号之前（更早）继续查询，0 表示从最新开始
+	Size   int       // 每页条数，最大 100
+}
+
+// params 转换为请求参数
+func (q *OrderQuery) params() map[string]string {
+	m := map[string]string{"symbol": q.Symbol}
+	if q.States != "" {
+		m["states"] = q.States
+	}
+	if !q.Start.IsZero() {
+		m["start-date"] = q.Start.Format("2006-01-02")
+	}
+	if !q.End.IsZero() {
+		m["end-date"] = q.End.Format("2006-01-02")
+	}
+	if q.From > 0 {
+		m["from"] = strconv.FormatUint(q.From, 10)
+		m["direct"] = "next"
+	}
+	if q.Size > 0 {
+		m["size"] = strconv.Itoa(q.Size)
+	}
+	return m
+}
+
 // huobiError 火币 API 错误码
 type huobiError struct {
 	Status string
@@ -126,19 +193,43 @@ type huobiError struct {
 	Message string `mapstructure:"err-msg" json:"err-msg"`
 }
 
-// NewClient 创建火币客户端
-func NewClient(host, key, secret string) (*Client, error) {
-	c := &Client{
-		host:   host,
-		key:    key,
-		secret: secret,
+// APIError 火币 API 返回的错误
+type APIError struct {
+	Code    string // 错误码，见 huobiError
+	Message string // 错误信息
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("Code: %s, %s", e.Code, e.Message)
+}
+
+// PlacedError 订单已提交但查询订单失败，订单可能已成交，不能重新下单
+type PlacedError struct {
+	OrderID uint64 // 已提交的订单号
+	Err     error  // 查询订单的错误
+}
+
+// Error 实现 error 接口
+func (e *PlacedError) Error() string {
+	return fmt.Sprintf("order %d placed but not confirmed: %v", e.OrderID, e.Err)
+}
+
+// IsSymbolNotFound 错误是否表示交易品种不存在：火币不支持的交易对，或返回 base-symbol-error
+func IsSymbolNotFound(err error) bool {
+	if errors.Cause(err) == errSymbolNotFound {
+		return true
 	}
+	e, ok := errors.Cause(err).(*APIError)
+	return ok && e.Code == "base-symbol-error"
+}
 
-	symbols, err := c.Symbols()
+// NewClient 创建火币客户端
+func NewClient(host, key, secret string) (*Client, error) {
+	c, err := NewPublicClient(host)
 	if err != nil {
 		return nil, errors.Wrap(err, util.FuncName())
 	}
-	c.symbols = symbols
+	c.key, c.secret = key, secret
 
 	accounts, err := c.Accounts()
 	if err != nil {
@@ -149,6 +240,19 @@ func NewClient(host, key, secret string) (*Client, error) {
 	return c, nil
 }
 
+// NewPublicClient 创建只访问行情接口的火币客户端，不需要 API 密钥
+func NewPublicClient(host string) (*Client, error) {
+	c := &Client{host: host}
+
+	symbols, err := c.Symbols()
+	if err != nil {
+		return nil, errors.Wrap(err, util.FuncName())
+	}
+	c.symbols = symbols
+
+	return c, nil
+}
+
 // Symbols 返回火币支持的所有交易品种
 func (c *Client) Symbols() ([]*Symbol, error) {
 	if len(c.symbols) != 0 {
@@ -200,6 +304,27 @@ func (c *Client) SymbolPrice(name string) (float64, error) {
 	return r.Tick.Data[0].Price, nil
 }
 
+// Klines 返回最近的 K 线，按时间倒序
+// 参数 period K 线周期：1min, 5min, 15min, 30min, 60min, 1day, 1mon, 1week, 1year
+// 参数 size   条数，最大 KlineLimit
+func (c *Client) Klines(symbol, period string, size int) ([]*Kline, error) {
+	m, err := c.req("GET", "/market/history/kline", map[string]string{
+		"symbol": symbol,
+		"period": period,
+		"size":   strconv.Itoa(size),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, util.FuncName())
+	}
+
+	r := struct{ Data []*Kline }{}
+	if err = decode(m, &r); err != nil {
+		return nil, errors.Wrap(err, util.FuncName())
+	}
+
+	return r.Data, nil
+}
+
 // Accounts 返回当前用户的账户列表（包括现货期货等）
 func (c *Client) Accounts() ([]*Account, error) {
 	if len(c.accounts) != 0 {
@@ -286,6 +411,36 @@ func (c *Client) OpenOrder(ID uint64) (*OpenOrder, error) {
 	return r.Data, nil
 }
 
+// Orders 查询历史订单
+func (c *Client) Orders(q *OrderQuery) ([]*OpenOrder, error) {
+	m, err := c.req("GET", "/v1/order/orders", q.params())
+	if err != nil {
+		return nil, errors.Wrap(err, util.FuncName())
+	}
+
+	r := struct{ Data []*OpenOrder }{}
+	if err = decode(m, &r); err != nil {
+		return nil, errors.Wrap(err, util.FuncName())
+	}
+
+	return r.Data, nil
+}
+
+// MatchResults 查询成交明细
+func (c *Client) MatchResults(q *OrderQuery) ([]*MatchResult, error) {
+	m, err := c.req("GET", "/v1/order/matchresults", q.params())
+	if err != nil {
+		return nil, errors.Wrap(err, util.FuncName())
+	}
+
+	r := struct{ Data []*MatchResult }{}
+	if err = decode(m, &r); err != nil {
+		return nil, errors.Wrap(err, util.FuncName())
+	}
+
+	return r.Data, nil
+}
+
 // Trade 发起一笔交易
 // 参数 amount 限价单表示下单数量，市价买单时表示买多少钱，市价卖单时表示卖多少币
 // 参数 price  限价单表示报价，市价单会忽略掉该参数
@@ -325,7 +480,7 @@ func (c *Client) Trade(symbol string, cmd TradeType, amount, price float64) (*Op
 	time.Sleep(time.Second * 5) // await until order state changed: submitted => filled
 	o, err := c.OpenOrder(r.Data)
 	if err != nil {
-		return nil, errors.Wrap(err, util.FuncName())
+		return nil, errors.Wrap(&PlacedError{OrderID: r.Data, Err: err}, util.FuncName())
 	}
 
 	return o, nil
@@ -383,8 +538,7 @@ func handle(bs []byte, err error) error {
 	}
 
 	if e.Status != "ok" {
-		err = fmt.Errorf("Code: %s, %s", e.Code, e.Message)
-		return errors.Wrap(err, util.FuncName())
+		return errors.Wrap(&APIError{Code: e.Code, Message: e.Message}, util.FuncName())
 	}
 
 	return nil
@@ -452,6 +606,11 @@ func (c *Client) req(method, address string, params map[string]string) (map[stri
 	}
 	req.Header.Set("Content-Type", ctype)
 
+	start := time.Now()
+	defer func() {
+		requestDuration.Observe(time.Since(start).Seconds(), "huobi", endpoint(path))
+	}()
+
 	var retry int
 t:
 	resp, err := client.Do(req)
@@ -488,6 +647,17 @@ t:
 	return m, nil
 }
 
+// endpoint 去掉路径中的编号，如 /v1/order/orders/{id}，避免每个订单一个序列
+func endpoint(path string) string {
+	parts := strings.Split(path, "/")
+	for i, p := range parts {
+		if _, err := strconv.ParseUint(p, 10, 64); err == nil {
+			parts[i] = "{id}"
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
 // decode convert an arbitrary map[string]interface{} into a Go structure.
 func decode(m map[string]interface{}, i interface{}) error {
 	decoder, err := mapstructure.NewDecoder(
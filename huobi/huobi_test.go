@@ -3,6 +3,7 @@ package huobi
 import (
 	"testing"
 
+	"github.com/pkg/errors"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -81,6 +82,18 @@ func TestSymbolPrice(t *testing.T) {
 	})
 }
 
+func TestKlines(t *testing.T) {
+	Convey("should return klines successfully", t, func() {
+		c, err := NewClient("https://api.huobi.pro", "apikey", "apisecret")
+		So(err, ShouldBeNil)
+
+		r, err := c.Klines("btcusdt", "1day", 10)
+		So(err, ShouldBeNil)
+		So(len(r), ShouldEqual, 10)
+		So(r[0].ID, ShouldBeGreaterThan, r[1].ID)
+	})
+}
+
 func TestSymbols(t *testing.T) {
 	Convey("should return all support symbol successfully", t, func() {
 		c, err := NewClient("https://api.huobi.pro", "apikey", "apisecret")
@@ -113,3 +126,47 @@ func TestTrade(t *testing.T) {
 		So(r, ShouldNotBeEmpty)
 	})
 }
+
+func TestOrders(t *testing.T) {
+	Convey("should return filled orders successfully", t, func() {
+		c, err := NewClient("https://api.huobi.pro", "apikey", "apisecret")
+		So(err, ShouldBeNil)
+
+		r, err := c.Orders(&OrderQuery{Symbol: "btcusdt", States: "filled", Size: 10})
+		So(err, ShouldBeNil)
+		So(len(r), ShouldBeLessThanOrEqualTo, 10)
+	})
+}
+
+func TestMatchResults(t *testing.T) {
+	Convey("should return match results successfully", t, func() {
+		c, err := NewClient("https://api.huobi.pro", "apikey", "apisecret")
+		So(err, ShouldBeNil)
+
+		r, err := c.MatchResults(&OrderQuery{Symbol: "btcusdt", Size: 10})
+		So(err, ShouldBeNil)
+		So(len(r), ShouldBeLessThanOrEqualTo, 10)
+	})
+}
+
+func TestHandle(t *testing.T) {
+	Convey("should return the api error code", t, func() {
+		err := handle([]byte(`{"status":"error","err-code":"order-accountbalance-error","err-msg":"balance insufficient"}`), nil)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldEndWith, "Code: order-accountbalance-error, balance insufficient")
+
+		e, ok := errors.Cause(err).(*APIError)
+		So(ok, ShouldBeTrue)
+		So(e.Code, ShouldEqual, "order-accountbalance-error")
+
+		So(handle([]byte(`{"status":"ok"}`), nil), ShouldBeNil)
+	})
+}
+
+func TestEndpoint(t *testing.T) {
+	Convey("should replace ids in the path", t, func() {
+		So(endpoint("/v1/order/orders/59378"), ShouldEqual, "/v1/order/orders/{id}")
+		So(endpoint("/v1/account/accounts/100009/balance"), ShouldEqual, "/v1/account/accounts/{id}/balance")
+		So(endpoint("/market/history/kline"), ShouldEqual, "/market/history/kline")
+	})
+}
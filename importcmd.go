@@ -0,0 +1,253 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/modood/aip/db"
+	"github.com/modood/aip/huobi"
+	"github.com/modood/aip/plan"
+	"github.com/modood/aip/util"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+const (
+	importPageSize = 100 // 每页查询条数，火币最多返回 100 条
+	importWindow   = 2   // 每次查询的天数，火币限制了订单查询的日期跨度
+	importHistory  = 180 // 未指定 --since 时导入的天数，火币只保留最近 180 天的订单
+)
+
+var errAmbiguousSymbol = errors.New("several plans trade the symbol, choose one with --plan")
+
+var importCmd = &cobra.Command{
+	Use:   "import",
+	Short: "import filled orders from the exchange order history",
+	Long: `Import filled orders from the exchange order history into the plans trading
+their symbol. Orders already recorded are updated instead of duplicated.`,
+	Args: cobra.NoArgs,
+	RunE: importOrders,
+}
+
+func init() {
+	importCmd.Flags().String("plan", "", "only import orders into the plan with this name")
+	importCmd.Flags().String("only-symbol", "", "only import orders of this symbol")
+	importCmd.Flags().String("since", "", "only import orders placed on or after this date, yyyy-mm-dd,\ndefaults to 180 days before --until")
+	importCmd.Flags().String("until", "", "only import orders placed before this date, yyyy-mm-dd, defaults to now")
+	importCmd.Flags().String("source", "", "only import orders placed from this source, e.g. api, web or app")
+}
+
+func importOrders(cmd *cobra.Command, args []string) error {
+	flags := cmd.Flags()
+	name, err := flags.GetString("plan")
+	if err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+	symbol, err := flags.GetString("only-symbol")
+	if err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+	source, err := flags.GetString("source")
+	if err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+
+	e, err := setupTrade()
+	if err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+
+	im := &importer{client: e.client, store: e.store, source: source, until: time.Now()}
+
+	// 日期按配置的时区解析
+	since, err := dateFlag(cmd, "since", e.loc)
+	if err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+	until, err := dateFlag(cmd, "until", e.loc)
+	if err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+	if until > 0 {
+		im.until = time.Unix(int64(until), 0)
+	}
+	im.since = im.until.AddDate(0, 0, -importHistory)
+	if since > 0 {
+		im.since = time.Unix(int64(since), 0)
+	}
+	im.since, im.until = im.since.In(e.loc), im.until.In(e.loc)
+
+	plans, err := e.plans(name)
+	if err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+
+	// 同一交易品种的订单只能导入一个计划
+	bySymbol := make(map[string]string)
+	for _, c := range plans {
+		if symbol != "" && c.Symbol != symbol {
+			continue
+		}
+		if _, ok := bySymbol[c.Symbol]; ok {
+			return errors.Wrap(errAmbiguousSymbol, c.Symbol)
+		}
+		bySymbol[c.Symbol] = c.Name
+	}
+	if len(bySymbol) == 0 {
+		return errors.Wrap(errPlanNotFound, symbol)
+	}
+
+	for _, c := range plans {
+		if bySymbol[c.Symbol] != c.Name {
+			continue
+		}
+
+		added, updated, err := im.run(c.Name, c.Symbol)
+		if err != nil {
+			return errors.Wrap(err, c.Name)
+		}
+		fmt.Printf("%s: imported %d new %s orders, updated %d\n", c.Name, added, c.Symbol, updated)
+	}
+
+	return nil
+}
+
+// importer 从火币历史订单导入订单
+type importer struct {
+	client *huobi.Client
+	store  db.Store
+	source string    // 只导入该来源的订单，为空时不限
+	since  time.Time // 下单时间不早于
+	until  time.Time // 下单时间早于
+}
+
+// run 导入交易品种的历史订单到定投计划，已存在的订单只更新成交信息
+// 返回值 added   新增的订单数
+// 返回值 updated 更新的订单数
+func (im *importer) run(name, symbol string) (added, updated int, err error) {
+	s, err := im.client.Symbol(symbol)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, util.FuncName())
+	}
+
+	if _, err = im.store.AddPlan(name); err != nil {
+		return 0, 0, errors.Wrap(err, util.FuncName())
+	}
+
+	orders, err := im.fetch(symbol)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, util.FuncName())
+	}
+
+	for _, o := range orders {
+		created, err := im.store.SaveOrder(plan.Record(name, s, o))
+		if err != nil {
+			return added, updated, errors.Wrap(err, util.FuncName())
+		}
+		if created {
+			added++
+		} else {
+			updated++
+		}
+	}
+
+	return added, updated, nil
+}
+
+// fetch 按日期窗口分页查询已成交的订单，并通过成交明细找回部分成交后撤销的订单，
+// 结果按下单时间排序
+func (im *importer) fetch(symbol string) ([]*huobi.OpenOrder, error) {
+	var (
+		r       []*huobi.OpenOrder
+		missing []uint64
+		seen    = make(map[uint64]bool)
+	)
+
+	// 火币按自己的时区划分日期，前后各多查一天，再按下单时间过滤
+	end := im.until.AddDate(0, 0, 1)
+	for start := im.since.AddDate(0, 0, -1); start.Before(end); start = start.AddDate(0, 0, importWindow) {
+		q := &huobi.OrderQuery{
+			Symbol: symbol,
+			States: "filled",
+			Start:  start,
+			End:    start.AddDate(0, 0, importWindow-1),
+		}
+
+		orders, err := orderHistory(im.client, q)
+		if err != nil {
+			return nil, errors.Wrap(err, util.FuncName())
+		}
+		for _, o := range orders {
+			if !seen[o.ID] && im.match(o.Source, o.CreatedAt) {
+				r = append(r, o)
+			}
+			seen[o.ID] = true
+		}
+
+		q.States, q.Size = "", importPageSize
+		for {
+			results, err := im.client.MatchResults(q)
+			if err != nil {
+				return nil, errors.Wrap(err, util.FuncName())
+			}
+			for _, m := range results {
+				if !seen[m.OrderID] && im.match(m.Source, m.CreatedAt) {
+					missing = append(missing, m.OrderID)
+				}
+				seen[m.OrderID] = true
+			}
+			if len(results) < importPageSize {
+				break
+			}
+			q.From = results[len(results)-1].ID
+		}
+	}
+
+	for _, id := range missing {
+		o, err := im.client.OpenOrder(id)
+		if err != nil {
+			return nil, errors.Wrap(err, util.FuncName())
+		}
+		// 尚未完成的订单由运行中的计划记录
+		if (o.State == "filled" || o.State == "partial-canceled") && im.match(o.Source, o.CreatedAt) {
+			r = append(r, o)
+		}
+	}
+
+	sort.Slice(r, func(i, j int) bool { return r[i].CreatedAt < r[j].CreatedAt })
+
+	return r, nil
+}
+
+// match 订单来源及下单时间（毫秒）是否符合导入条件
+func (im *importer) match(source string, created uint64) bool {
+	if im.source != "" && source != im.source {
+		return false
+	}
+
+	t := time.Unix(0, int64(created)*int64(time.Millisecond))
+	return !t.Before(im.since) && t.Before(im.until)
+}
+
+// orderHistory 分页查询符合条件的全部历史订单，忽略条件中的 From 及 Size
+func orderHistory(client *huobi.Client, q *huobi.OrderQuery) ([]*huobi.OpenOrder, error) {
+	var (
+		r    []*huobi.OpenOrder
+		page = *q
+	)
+
+	page.From, page.Size = 0, importPageSize
+	for {
+		orders, err := client.Orders(&page)
+		if err != nil {
+			return nil, errors.Wrap(err, util.FuncName())
+		}
+		r = append(r, orders...)
+		if len(orders) < importPageSize {
+			return r, nil
+		}
+		page.From = orders[len(orders)-1].ID
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/modood/aip/db"
+	"github.com/modood/aip/huobi"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// exchange 模拟火币的订单历史及账户余额接口
+func exchange(orders []*huobi.OpenOrder, balance float64) *httptest.Server {
+	ok := func(w http.ResponseWriter, data interface{}) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"status": "ok", "data": data})
+	}
+	date := func(ms uint64) string {
+		return time.Unix(int64(ms/1000), 0).UTC().Format(dateLayout)
+	}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		from, _ := strconv.ParseUint(q.Get("from"), 10, 64)
+		size, _ := strconv.Atoi(q.Get("size"))
+
+		// 按编号倒序返回日期范围内的一页
+		page := func(states string) []*huobi.OpenOrder {
+			var r []*huobi.OpenOrder
+			for _, o := range orders {
+				d := date(o.CreatedAt)
+				if d < q.Get("start-date") || d > q.Get("end-date") ||
+					(states != "" && !contains(strings.Split(states, ","), o.State)) ||
+					(from > 0 && o.ID >= from) {
+					continue
+				}
+				r = append(r, o)
+			}
+			sort.Slice(r, func(i, j int) bool { return r[i].ID > r[j].ID })
+			if len(r) > size {
+				r = r[:size]
+			}
+			return r
+		}
+
+		switch path := r.URL.Path; {
+		case path == "/v1/common/symbols":
+			ok(w, []*huobi.Symbol{{Symbol: "btcusdt", BaseCurrency: "btc", QuoteCurrency: "usdt", AmountPrecision: 6}})
+		case path == "/v1/account/accounts":
+			ok(w, []*huobi.Account{{ID: 1, Type: "spot"}})
+		case path == "/v1/account/accounts/1/balance":
+			ok(w, map[string]interface{}{"id": 1, "type": "spot", "list": []map[string]interface{}{
+				{"currency": "btc", "type": "trade", "balance": balance}}})
+		case path == "/v1/order/orders":
+			ok(w, page(q.Get("states")))
+		case path == "/v1/order/matchresults":
+			var r []*huobi.MatchResult
+			for _, o := range page("") {
+				r = append(r, &huobi.MatchResult{ID: o.ID, OrderID: o.ID, Symbol: o.Symbol,
+					Source: o.Source, CreatedAt: o.CreatedAt})
+			}
+			ok(w, r)
+		case strings.HasPrefix(path, "/v1/order/orders/"):
+			id, _ := strconv.ParseUint(strings.TrimPrefix(path, "/v1/order/orders/"), 10, 64)
+			for _, o := range orders {
+				if o.ID == id {
+					ok(w, o)
+				}
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func TestImport(t *testing.T) {
+	Convey("should import filled orders once", t, func() {
+		since := time.Date(2018, 9, 1, 0, 0, 0, 0, time.UTC)
+
+		// 250 笔订单，每 20 分钟一笔，跨越多个日期窗口，且每个窗口需要分页
+		var orders []*huobi.OpenOrder
+		for i := 0; i < 250; i++ {
+			created := uint64(since.Add(time.Duration(i)*20*time.Minute).Unix() * 1000)
+			orders = append(orders, &huobi.OpenOrder{ID: uint64(1000 + i), Symbol: "btcusdt",
+				Type: "buy-market", State: "filled", Source: "api", Amount: 10,
+				FieldAmount: 0.001, FieldCashAmount: 10, FieldFees: 0.000002, CreatedAt: created})
+		}
+		orders[10].Source = "web"
+		orders[20].State = "partial-canceled"
+		orders[30].State = "canceled"
+
+		server := exchange(orders, 0)
+		defer server.Close()
+
+		client, err := huobi.NewClient(server.URL, "apikey", "apisecret")
+		So(err, ShouldBeNil)
+
+		path := filepath.Join(os.TempDir(), fmt.Sprintf("aip-import-%d.sqlite3", time.Now().UnixNano()))
+		defer os.Remove(path)
+		store, err := db.Init(db.DriverSQLite, path)
+		So(err, ShouldBeNil)
+		defer store.Close()
+
+		im := &importer{client: client, store: store, source: "api",
+			since: since, until: since.AddDate(0, 0, 30)}
+
+		added, updated, err := im.run("default", "btcusdt")
+		So(err, ShouldBeNil)
+		So(added, ShouldEqual, 248)
+		So(updated, ShouldEqual, 0)
+
+		recorded, err := store.Orders(&db.Filter{Plan: "default"})
+		So(err, ShouldBeNil)
+		So(len(recorded), ShouldEqual, 248)
+
+		partial, err := store.Orders(&db.Filter{Since: orders[20].CreatedAt / 1000, Until: orders[21].CreatedAt / 1000})
+		So(err, ShouldBeNil)
+		So(len(partial), ShouldEqual, 1)
+		So(partial[0].State, ShouldEqual, "partial-canceled")
+
+		added, updated, err = im.run("default", "btcusdt")
+		So(err, ShouldBeNil)
+		So(added, ShouldEqual, 0)
+		So(updated, ShouldEqual, 248)
+	})
+
+	Convey("should record imported sells as negative amounts", t, func() {
+		since := time.Date(2018, 9, 1, 0, 0, 0, 0, time.UTC)
+		created := func(i int) uint64 { return uint64(since.Add(time.Duration(i)*time.Hour).Unix() * 1000) }
+		orders := []*huobi.OpenOrder{
+			{ID: 1, Symbol: "btcusdt", Type: "buy-market", State: "filled", Source: "api", Amount: 10,
+				FieldAmount: 0.001, FieldCashAmount: 10, FieldFees: 0.000002, CreatedAt: created(0)},
+			{ID: 2, Symbol: "btcusdt", Type: "buy-market", State: "filled", Source: "api", Amount: 10,
+				FieldAmount: 0.001, FieldCashAmount: 10, FieldFees: 0.000002, CreatedAt: created(1)},
+			{ID: 3, Symbol: "btcusdt", Type: "sell-market", State: "filled", Source: "api", Amount: 0.001,
+				FieldAmount: 0.001, FieldCashAmount: 7, FieldFees: 0.014, CreatedAt: created(2)},
+		}
+
+		server := exchange(orders, 0)
+		defer server.Close()
+
+		client, err := huobi.NewClient(server.URL, "apikey", "apisecret")
+		So(err, ShouldBeNil)
+
+		path := filepath.Join(os.TempDir(), fmt.Sprintf("aip-import-sell-%d.sqlite3", time.Now().UnixNano()))
+		defer os.Remove(path)
+		store, err := db.Init(db.DriverSQLite, path)
+		So(err, ShouldBeNil)
+		defer store.Close()
+
+		im := &importer{client: client, store: store, since: since, until: since.AddDate(0, 0, 1)}
+		added, _, err := im.run("default", "btcusdt")
+		So(err, ShouldBeNil)
+		So(added, ShouldEqual, 3)
+
+		position, investment, err := store.OrderSummary("default", "btcusdt")
+		So(err, ShouldBeNil)
+		So(position, ShouldAlmostEqual, 0.000996, 1e-12)
+		So(investment, ShouldAlmostEqual, 13.014, 1e-9)
+
+		sells, err := store.Orders(&db.Filter{Since: created(2) / 1000})
+		So(err, ShouldBeNil)
+		So(sells[0].BaseAmount, ShouldEqual, -0.001)
+		So(sells[0].QuoteAmount, ShouldEqual, -7)
+		So(sells[0].Price, ShouldEqual, 7000)
+	})
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"log"
+
+	"github.com/modood/aip/plan"
+	"github.com/modood/aip/util"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var (
+	errOnceRequired = errors.New("invest places a real order, pass --once to confirm")
+	errPlanRequired = errors.New("more than one plan configured, choose one with --plan")
+)
+
+var investCmd = &cobra.Command{
+	Use:   "invest",
+	Short: "invest the amount of a plan once, right now",
+	Args:  cobra.NoArgs,
+	RunE:  invest,
+}
+
+func init() {
+	investCmd.Flags().Bool("once", false, "place a single order now and exit")
+	investCmd.Flags().String("plan", "", "name of the plan to invest, required when more than one plan configured")
+}
+
+func invest(cmd *cobra.Command, args []string) error {
+	once, err := cmd.Flags().GetBool("once")
+	if err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+	if !once {
+		return errors.Wrap(errOnceRequired, util.FuncName())
+	}
+
+	name, err := cmd.Flags().GetString("plan")
+	if err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+
+	e, err := setupTrade()
+	if err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+
+	plans, err := e.plans(name)
+	if err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+	if len(plans) > 1 {
+		return errors.Wrap(errPlanRequired, util.FuncName())
+	}
+
+	opts, err := plans[0].Options()
+	if err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+
+	p, err := plan.New(opts, e.client, e.store)
+	if err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+
+	if err = p.Invest(); err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+
+	log.Printf("plan %s invested %v %s\n", p.Name(), opts.Amount, opts.Symbol)
+
+	return nil
+}
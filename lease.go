@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/modood/aip/db"
+	"github.com/modood/aip/util"
+
+	"github.com/pkg/errors"
+)
+
+// leaseTTL 计划租约的有效期，运行期间每 leaseTTL/3 续期一次，
+// 实例异常退出后，其他实例最迟在 leaseTTL 后可以接管
+const leaseTTL = time.Minute
+
+var errPlanRunning = errors.New("plan is running in another instance")
+
+// instanceID 当前实例标识，主机名/进程号
+func instanceID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s/%d", host, os.Getpid())
+}
+
+// acquire 获取或续期计划的租约。持有者是本机已退出的进程时直接接管
+func (d *daemon) acquire(name string) error {
+	now := time.Now()
+	expires := uint64(now.Add(leaseTTL).Unix())
+
+	l, err := d.store.AcquireLease(name, d.owner, uint64(now.Unix()), expires)
+	if err == nil {
+		return nil
+	}
+	if errors.Cause(err) != db.ErrLeaseHeld {
+		return errors.Wrap(err, util.FuncName())
+	}
+
+	if exited(l.Owner) {
+		log.Printf("plan %s: instance %s has exited, taking over its lease\n", name, l.Owner)
+		if err = d.store.TakeoverLease(name, d.owner, l.Owner, expires); err == nil {
+			return nil
+		}
+		log.Println(err)
+	}
+
+	return errors.Wrapf(errPlanRunning, "plan %s is locked by %s until %s, stop that instance or wait for the lock to expire",
+		name, l.Owner, time.Unix(int64(l.Expires), 0).In(d.loc).Format(time.RFC3339))
+}
+
+// exited 租约持有者是否为本机上已退出的进程
+func exited(owner string) bool {
+	i := strings.LastIndex(owner, "/")
+	if i < 0 {
+		return false
+	}
+
+	host, err := os.Hostname()
+	if err != nil || owner[:i] != host {
+		return false
+	}
+
+	pid, err := strconv.Atoi(owner[i+1:])
+	if err != nil || pid <= 0 || pid == os.Getpid() {
+		return false
+	}
+
+	return syscall.Kill(pid, 0) == syscall.ESRCH
+}
@@ -0,0 +1,271 @@
+package lots
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/modood/aip/db"
+	"github.com/modood/aip/util"
+
+	"github.com/pkg/errors"
+)
+
+// Method 卖单匹配买入批次的方法
+type Method string
+
+// 匹配方法
+const (
+	FIFO    Method = "fifo"    // 先买先卖
+	LIFO    Method = "lifo"    // 后买先卖
+	HIFO    Method = "hifo"    // 单位成本最高的先卖
+	Average Method = "average" // 移动加权平均成本，所有买单合并为一个批次
+)
+
+// epsilon 浮点误差，小于该数量的剩余视为 0
+const epsilon = 1e-12
+
+var (
+	errUnknownMethod = errors.New("unknown method, available: fifo, lifo, hifo and average")
+
+	// ErrOversold 卖出数量超过之前买入的批次，通常是缺少更早的买单
+	ErrOversold = errors.New("sell exceeds the lots bought before it, import the missing orders first")
+)
+
+// Lot 批次，一笔买单扣除手续费后得到的持仓
+type Lot struct {
+	OrderID  uint64  // 买单号，平均成本法为 0
+	Symbol   string  // 交易品种
+	Acquired uint64  // 买入时间，平均成本法为第一笔买入的时间
+	Amount   float64 // 剩余数量（基础货币）
+	Cost     float64 // 剩余成本（报价货币，含手续费）
+}
+
+// Disposal 卖单与一个批次的匹配结果
+type Disposal struct {
+	OrderID  uint64  // 卖单号
+	LotID    uint64  // 批次的买单号，平均成本法为 0
+	Symbol   string  // 交易品种
+	Acquired uint64  // 买入时间
+	Disposed uint64  // 卖出时间
+	Amount   float64 // 卖出数量（基础货币）
+	Proceeds float64 // 扣除手续费后的卖出所得，按数量分摊（报价货币）
+	Cost     float64 // 卖出部分的成本（报价货币）
+}
+
+// Gain 已实现盈亏
+func (d *Disposal) Gain() float64 {
+	return d.Proceeds - d.Cost
+}
+
+// Book 单个交易品种的批次账本，订单需按时间顺序加入
+type Book struct {
+	Symbol    string      // 交易品种
+	Base      string      // 基础货币，用于判断手续费币种
+	Method    Method      // 匹配方法
+	Lots      []*Lot      // 尚未卖完的批次，按买入时间排序
+	Disposals []*Disposal // 全部卖出匹配结果
+	Invested  float64     // 累计买入花费（报价货币，含手续费）
+	Fees      float64     // 累计手续费，按成交价折算为报价货币
+}
+
+// New 创建交易品种的批次账本
+func New(symbol, base string, method Method) (*Book, error) {
+	switch method {
+	case FIFO, LIFO, HIFO, Average:
+	default:
+		return nil, errors.Wrap(errUnknownMethod, string(method))
+	}
+
+	return &Book{Symbol: symbol, Base: base, Method: method}, nil
+}
+
+// Add 加入一笔订单，买单形成批次，卖单按方法匹配批次，未成交的订单忽略。
+// 卖单的成交金额按记录为负数，取绝对值匹配批次。
+// 手续费计入成本或从所得中扣除；旧订单没有手续费币种，按火币规则买单以基础货币、卖单以报价货币收取
+func (b *Book) Add(o *db.Order) error {
+	if o.BaseAmount == 0 {
+		return nil
+	}
+
+	sell := o.Type == "sell-market" || o.Type == "sell-limit"
+	baseFee := o.FeeCurrency == b.Base || (o.FeeCurrency == "" && !sell)
+	if baseFee {
+		b.Fees += o.Fees * o.Price
+	} else {
+		b.Fees += o.Fees
+	}
+
+	if !sell {
+		l := &Lot{OrderID: o.ID, Symbol: o.Symbol, Acquired: o.Created, Amount: o.BaseAmount, Cost: o.QuoteAmount}
+		if baseFee {
+			l.Amount -= o.Fees
+		} else {
+			l.Cost += o.Fees
+		}
+		b.Invested += l.Cost
+		b.buy(l)
+		return nil
+	}
+
+	amount, proceeds := -o.BaseAmount, -o.QuoteAmount
+	if baseFee {
+		amount += o.Fees
+	} else {
+		proceeds -= o.Fees
+	}
+	if err := b.sell(o, amount, proceeds); err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+
+	return nil
+}
+
+// buy 新增批次，平均成本法合并到唯一的批次
+func (b *Book) buy(l *Lot) {
+	if b.Method != Average || len(b.Lots) == 0 {
+		b.Lots = append(b.Lots, l)
+		return
+	}
+
+	pool := b.Lots[0]
+	pool.OrderID = 0
+	pool.Amount += l.Amount
+	pool.Cost += l.Cost
+}
+
+// sell 按方法依次匹配批次，所得按数量分摊到各批次
+func (b *Book) sell(o *db.Order, amount, proceeds float64) error {
+	for remaining := amount; remaining > epsilon; {
+		if len(b.Lots) == 0 {
+			return errors.Wrapf(ErrOversold, "order %d", o.ID)
+		}
+
+		i := b.next()
+		l := b.Lots[i]
+		take := math.Min(l.Amount, remaining)
+		cost := l.Cost * take / l.Amount
+
+		b.Disposals = append(b.Disposals, &Disposal{
+			OrderID:  o.ID,
+			LotID:    l.OrderID,
+			Symbol:   o.Symbol,
+			Acquired: l.Acquired,
+			Disposed: o.Created,
+			Amount:   take,
+			Proceeds: proceeds * take / amount,
+			Cost:     cost,
+		})
+
+		l.Amount -= take
+		l.Cost -= cost
+		if l.Amount <= epsilon {
+			b.Lots = append(b.Lots[:i], b.Lots[i+1:]...)
+		}
+		remaining -= take
+	}
+
+	return nil
+}
+
+// next 返回下一个匹配的批次
+func (b *Book) next() int {
+	switch b.Method {
+	case LIFO:
+		return len(b.Lots) - 1
+	case HIFO:
+		i := 0
+		for j, l := range b.Lots {
+			if l.Cost/l.Amount > b.Lots[i].Cost/b.Lots[i].Amount {
+				i = j
+			}
+		}
+		return i
+	}
+	return 0
+}
+
+// Holding 返回尚未卖出的数量及成本
+func (b *Book) Holding() (amount, cost float64) {
+	for _, l := range b.Lots {
+		amount += l.Amount
+		cost += l.Cost
+	}
+	return amount, cost
+}
+
+// Year 年度盈亏
+type Year struct {
+	Year        int     // 年份
+	Symbol      string  // 交易品种
+	Method      Method  // 匹配方法
+	Invested    float64 // 当年买入花费（报价货币，含手续费）
+	Proceeds    float64 // 当年卖出所得（扣除手续费）
+	Cost        float64 // 当年卖出部分的成本
+	Realized    float64 // 已实现盈亏
+	Fees        float64 // 当年手续费（报价货币）
+	Holding     float64 // 年末持仓（基础货币）
+	HoldingCost float64 // 年末持仓成本
+	Price       float64 // 年末价格，当年为当前价格
+	Unrealized  float64 // 年末未实现盈亏
+}
+
+// PriceFunc 返回指定时间之前的最新价格
+type PriceFunc func(at time.Time) (float64, error)
+
+// Years 按时间顺序重建账本，逐年汇总已实现及未实现盈亏，直到 now 所在的年份。
+// 年份按 loc 时区划分，年末价格为下一年开始时的价格，当年为 now 的价格
+func Years(b *Book, orders []*db.Order, loc *time.Location, now time.Time, price PriceFunc) ([]*Year, error) {
+	if len(orders) == 0 {
+		return nil, nil
+	}
+
+	sorted := make([]*db.Order, len(orders))
+	copy(sorted, orders)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Created != sorted[j].Created {
+			return sorted[i].Created < sorted[j].Created
+		}
+		return sorted[i].ID < sorted[j].ID
+	})
+
+	var (
+		r    []*Year
+		next int
+		last = now.In(loc).Year()
+	)
+	for year := time.Unix(int64(sorted[0].Created), 0).In(loc).Year(); year <= last; year++ {
+		end := time.Date(year+1, 1, 1, 0, 0, 0, 0, loc)
+		if end.After(now) {
+			end = now
+		}
+
+		y := &Year{Year: year, Symbol: b.Symbol, Method: b.Method}
+		invested, fees, disposals := b.Invested, b.Fees, len(b.Disposals)
+		for ; next < len(sorted) && (year == last || sorted[next].Created < uint64(end.Unix())); next++ {
+			if err := b.Add(sorted[next]); err != nil {
+				return nil, errors.Wrap(err, util.FuncName())
+			}
+		}
+		for _, d := range b.Disposals[disposals:] {
+			y.Proceeds += d.Proceeds
+			y.Cost += d.Cost
+		}
+		y.Realized = y.Proceeds - y.Cost
+		y.Invested, y.Fees = b.Invested-invested, b.Fees-fees
+
+		y.Holding, y.HoldingCost = b.Holding()
+		if y.Holding > 0 {
+			p, err := price(end)
+			if err != nil {
+				return nil, errors.Wrap(err, util.FuncName())
+			}
+			y.Price = p
+			y.Unrealized = y.Holding*p - y.HoldingCost
+		}
+
+		r = append(r, y)
+	}
+
+	return r, nil
+}
@@ -0,0 +1,134 @@
+package lots
+
+import (
+	"testing"
+	"time"
+
+	"github.com/modood/aip/db"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// unix 返回 UTC 日期的 unix 时间
+func unix(year int, month time.Month, day int) uint64 {
+	return uint64(time.Date(year, month, day, 0, 0, 0, 0, time.UTC).Unix())
+}
+
+// orders 两笔买单（单价 100 及 200）后卖出 1.5 个，卖单与计划记录的一致，成交金额为负数
+var orders = []*db.Order{
+	{ID: 1, Symbol: "btcusdt", Type: "buy-market", Price: 100, BaseAmount: 1, QuoteAmount: 100, Created: unix(2017, 3, 1)},
+	{ID: 2, Symbol: "btcusdt", Type: "buy-limit", Price: 200, BaseAmount: 1, QuoteAmount: 200, Created: unix(2017, 6, 1)},
+	{ID: 3, Symbol: "btcusdt", Type: "sell-market", Price: 300, BaseAmount: -1.5, QuoteAmount: -450,
+		FeeCurrency: "usdt", Created: unix(2018, 1, 5)},
+}
+
+func book(method Method) *Book {
+	b, err := New("btcusdt", "btc", method)
+	So(err, ShouldBeNil)
+	for _, o := range orders {
+		So(b.Add(o), ShouldBeNil)
+	}
+	return b
+}
+
+func TestMethods(t *testing.T) {
+	Convey("should match sells by fifo", t, func() {
+		b := book(FIFO)
+		So(len(b.Disposals), ShouldEqual, 2)
+		So(b.Disposals[0].LotID, ShouldEqual, 1)
+		So(b.Disposals[0].Gain(), ShouldEqual, 200)
+		So(b.Disposals[1].LotID, ShouldEqual, 2)
+		So(b.Disposals[1].Amount, ShouldEqual, 0.5)
+		So(b.Disposals[1].Gain(), ShouldEqual, 50)
+
+		amount, cost := b.Holding()
+		So(amount, ShouldEqual, 0.5)
+		So(cost, ShouldEqual, 100)
+	})
+
+	Convey("should match sells by lifo and hifo", t, func() {
+		for _, m := range []Method{LIFO, HIFO} {
+			b := book(m)
+			So(b.Disposals[0].LotID, ShouldEqual, 2)
+			So(b.Disposals[0].Gain(), ShouldEqual, 100)
+			So(b.Disposals[1].LotID, ShouldEqual, 1)
+			So(b.Disposals[1].Gain(), ShouldEqual, 100)
+
+			_, cost := b.Holding()
+			So(cost, ShouldEqual, 50)
+		}
+	})
+
+	Convey("should match sells by average cost", t, func() {
+		b := book(Average)
+		So(len(b.Disposals), ShouldEqual, 1)
+		So(b.Disposals[0].LotID, ShouldEqual, 0)
+		So(b.Disposals[0].Cost, ShouldEqual, 225)
+
+		amount, cost := b.Holding()
+		So(amount, ShouldEqual, 0.5)
+		So(cost, ShouldEqual, 75)
+	})
+
+	Convey("should include fees in cost and proceeds", t, func() {
+		b, err := New("btcusdt", "btc", FIFO)
+		So(err, ShouldBeNil)
+		So(b.Add(&db.Order{ID: 1, Type: "buy-market", Price: 100, BaseAmount: 1, QuoteAmount: 100,
+			Fees: 0.002, FeeCurrency: "btc"}), ShouldBeNil)
+		So(b.Add(&db.Order{ID: 2, Type: "sell-market", Price: 200, BaseAmount: -0.998, QuoteAmount: -199.6,
+			Fees: 0.3992, FeeCurrency: "usdt"}), ShouldBeNil)
+
+		So(len(b.Lots), ShouldEqual, 0)
+		So(b.Disposals[0].Cost, ShouldEqual, 100)
+		So(b.Disposals[0].Proceeds, ShouldAlmostEqual, 199.2008)
+		So(b.Fees, ShouldAlmostEqual, 0.5992)
+	})
+
+	Convey("should reject selling more than bought", t, func() {
+		b, err := New("btcusdt", "btc", FIFO)
+		So(err, ShouldBeNil)
+		err = b.Add(&db.Order{ID: 1, Type: "sell-market", Price: 200, BaseAmount: -1, QuoteAmount: -200,
+			FeeCurrency: "usdt"})
+		So(err, ShouldNotBeNil)
+
+		_, err = New("btcusdt", "btc", "random")
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestYears(t *testing.T) {
+	Convey("should report realized and unrealized gains per year", t, func() {
+		b, err := New("btcusdt", "btc", FIFO)
+		So(err, ShouldBeNil)
+
+		prices := map[int64]float64{
+			time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC).Unix(): 250,
+			time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC).Unix(): 150,
+		}
+		now := time.Date(2019, 6, 1, 0, 0, 0, 0, time.UTC)
+		prices[now.Unix()] = 400
+
+		years, err := Years(b, orders, time.UTC, now, func(at time.Time) (float64, error) {
+			return prices[at.Unix()], nil
+		})
+		So(err, ShouldBeNil)
+		So(len(years), ShouldEqual, 3)
+
+		So(years[0].Year, ShouldEqual, 2017)
+		So(years[0].Invested, ShouldEqual, 300)
+		So(years[0].Realized, ShouldEqual, 0)
+		So(years[0].Holding, ShouldEqual, 2)
+		So(years[0].Unrealized, ShouldEqual, 200)
+
+		So(years[1].Year, ShouldEqual, 2018)
+		So(years[1].Proceeds, ShouldEqual, 450)
+		So(years[1].Realized, ShouldEqual, 250)
+		So(years[1].Holding, ShouldEqual, 0.5)
+		So(years[1].Unrealized, ShouldEqual, -25)
+
+		So(years[2].Year, ShouldEqual, 2019)
+		So(years[2].Realized, ShouldEqual, 0)
+		So(years[2].Price, ShouldEqual, 400)
+		So(years[2].Unrealized, ShouldEqual, 100)
+	})
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/modood/aip/db"
+	"github.com/modood/aip/lots"
+	"github.com/modood/aip/util"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var errUnknownReport = errors.New("unknown report, available: years, disposals and lots")
+
+// 批次报表的列名：年度盈亏、卖单与批次的匹配结果及尚未卖完的批次。
+// 金额为报价货币，数量为基础货币；disposals 的 lot_id 及 lots 的 order_id 为批次的买单号，
+// 平均成本法合并的批次为 0。列名只能追加，不能修改
+var (
+	yearColumns = []string{"year", "symbol", "method", "invested", "proceeds", "cost", "realized",
+		"fees", "holding", "holding_cost", "price", "unrealized"}
+	disposalColumns = []string{"order_id", "lot_id", "symbol", "acquired", "disposed", "amount",
+		"proceeds", "cost", "gain"}
+	lotColumns = []string{"order_id", "symbol", "acquired", "amount", "cost", "unit_cost"}
+)
+
+var lotsCmd = &cobra.Command{
+	Use:   "lots",
+	Short: "report realized and unrealized gains per year from tax lots",
+	Long: `Build tax lots from the recorded buy orders, match the sell orders against them
+and report realized and unrealized gains per year, the matched sells (disposals) or
+the lots still held. Fees are included in the cost of buys and deducted from the
+proceeds of sells. Orders of all plans trading a symbol share the same lots.`,
+	Args: cobra.NoArgs,
+	RunE: lotsReport,
+}
+
+func init() {
+	lotsCmd.Flags().String("method", string(lots.FIFO), "how sells are matched to lots: fifo, lifo, hifo or average")
+	lotsCmd.Flags().String("report", "years", "report to export: years, disposals or lots")
+	lotsCmd.Flags().String("format", formatCSV, "output format: csv, jsonl, or excel for csv that excel opens directly")
+	lotsCmd.Flags().StringP("output", "o", "", "file to write to, defaults to stdout")
+	lotsCmd.Flags().String("plan", "", "only use orders of the plan with this name")
+	lotsCmd.Flags().String("only-symbol", "", "only report this symbol")
+}
+
+func lotsReport(cmd *cobra.Command, args []string) error {
+	var (
+		x   = &exporter{}
+		err error
+	)
+
+	flags := cmd.Flags()
+	method, err := flags.GetString("method")
+	if err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+	report, err := flags.GetString("report")
+	if err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+	if report != "years" && report != "disposals" && report != "lots" {
+		return errors.Wrap(errUnknownReport, report)
+	}
+	if x.format, err = formatFlag(cmd); err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+	output, err := flags.GetString("output")
+	if err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+	name, err := flags.GetString("plan")
+	if err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+	symbol, err := flags.GetString("only-symbol")
+	if err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+
+	e, err := setup()
+	if err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+	x.loc = e.loc
+	x.market = e.client
+
+	plans, err := e.plans(name)
+	if err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+
+	var symbols []string
+	seen := make(map[string]bool)
+	for _, c := range plans {
+		if !seen[c.Symbol] && (symbol == "" || c.Symbol == symbol) {
+			symbols = append(symbols, c.Symbol)
+		}
+		seen[c.Symbol] = true
+	}
+	if len(symbols) == 0 {
+		return errors.Wrap(errPlanNotFound, symbol)
+	}
+	sort.Strings(symbols)
+
+	var (
+		now       = time.Now().In(e.loc)
+		years     []*lots.Year
+		disposals []*lots.Disposal
+		held      []*lots.Lot
+	)
+	for _, s := range symbols {
+		sym, err := e.client.Symbol(s)
+		if err != nil {
+			return errors.Wrap(err, util.FuncName())
+		}
+		b, err := lots.New(s, sym.BaseCurrency, lots.Method(method))
+		if err != nil {
+			return errors.Wrap(err, util.FuncName())
+		}
+
+		orders, err := e.store.Orders(&db.Filter{Plan: name, Symbol: s})
+		if err != nil {
+			return errors.Wrap(err, util.FuncName())
+		}
+
+		y, err := lots.Years(b, orders, e.loc, now, e.priceAt(s, now))
+		if err != nil {
+			return errors.Wrap(err, s)
+		}
+		years = append(years, y...)
+		disposals = append(disposals, b.Disposals...)
+		held = append(held, b.Lots...)
+	}
+
+	w, closer, err := create(output)
+	if err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+	defer closer()
+	x.w = w
+
+	switch report {
+	case "disposals":
+		err = x.disposals(disposals)
+	case "lots":
+		err = x.lots(held)
+	default:
+		err = x.years(years)
+	}
+	if err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+
+	return nil
+}
+
+// priceAt 返回交易品种在指定时间之前的最新价格：不早于 now 时为当前价格，
+// 否则为当时最近的统计价格，没有统计时为最近的成交价格
+func (e *env) priceAt(symbol string, now time.Time) lots.PriceFunc {
+	return func(at time.Time) (float64, error) {
+		if !at.Before(now) {
+			return e.client.SymbolPrice(symbol)
+		}
+
+		f := &db.Filter{Symbol: symbol, Until: uint64(at.Unix()), Limit: 1}
+		stats, err := e.store.ListStatistics(f)
+		if err != nil {
+			return 0, errors.Wrap(err, util.FuncName())
+		}
+		if len(stats) > 0 {
+			return stats[0].Price, nil
+		}
+
+		orders, err := e.store.Orders(f)
+		if err != nil {
+			return 0, errors.Wrap(err, util.FuncName())
+		}
+		if len(orders) > 0 {
+			return orders[0].Price, nil
+		}
+
+		return 0, nil
+	}
+}
+
+// years 导出年度盈亏
+func (x *exporter) years(years []*lots.Year) error {
+	rows := make([][]cell, 0, len(years))
+	for _, y := range years {
+		price, amount, value := x.precision(y.Symbol)
+		rows = append(rows, []cell{
+			{text: strconv.Itoa(y.Year), number: true},
+			{text: y.Symbol},
+			{text: string(y.Method)},
+			decimal(y.Invested, value),
+			decimal(y.Proceeds, value),
+			decimal(y.Cost, value),
+			decimal(y.Realized, value),
+			decimal(y.Fees, value),
+			decimal(y.Holding, amount),
+			decimal(y.HoldingCost, value),
+			decimal(y.Price, price),
+			decimal(y.Unrealized, value),
+		})
+	}
+
+	return x.write(yearColumns, rows)
+}
+
+// disposals 导出卖单与批次的匹配结果
+func (x *exporter) disposals(disposals []*lots.Disposal) error {
+	rows := make([][]cell, 0, len(disposals))
+	for _, d := range disposals {
+		_, amount, value := x.precision(d.Symbol)
+		rows = append(rows, []cell{
+			{text: strconv.FormatUint(d.OrderID, 10), number: true},
+			{text: strconv.FormatUint(d.LotID, 10), number: true},
+			{text: d.Symbol},
+			x.time(d.Acquired),
+			x.time(d.Disposed),
+			decimal(d.Amount, amount),
+			decimal(d.Proceeds, value),
+			decimal(d.Cost, value),
+			decimal(d.Gain(), value),
+		})
+	}
+
+	return x.write(disposalColumns, rows)
+}
+
+// lots 导出尚未卖完的批次
+func (x *exporter) lots(held []*lots.Lot) error {
+	rows := make([][]cell, 0, len(held))
+	for _, l := range held {
+		price, amount, value := x.precision(l.Symbol)
+		rows = append(rows, []cell{
+			{text: strconv.FormatUint(l.OrderID, 10), number: true},
+			{text: l.Symbol},
+			x.time(l.Acquired),
+			decimal(l.Amount, amount),
+			decimal(l.Cost, value),
+			decimal(l.Cost/l.Amount, price),
+		})
+	}
+
+	return x.write(lotColumns, rows)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/modood/aip/metrics"
+	"github.com/modood/aip/util"
+
+	"github.com/pkg/errors"
+)
+
+// 计划的状态指标，抓取时从运行中的计划收集，已删除或停止的计划不再输出
+var (
+	planPosition = metrics.NewGauge("aip_plan_position",
+		"Position of the plan in the base currency.", "plan", "symbol")
+	planInvestment = metrics.NewGauge("aip_plan_investment",
+		"Investment of the plan in the quote currency.", "plan", "symbol")
+	planPrice = metrics.NewGauge("aip_plan_price",
+		"Last price of the plan's symbol.", "plan", "symbol")
+	planEquity = metrics.NewGauge("aip_plan_equity",
+		"Equity of the plan in the quote currency.", "plan", "symbol")
+	planPaused = metrics.NewGauge("aip_plan_paused",
+		"Whether the plan is paused, 1 or 0.", "plan")
+	planNextRun = metrics.NewGauge("aip_plan_next_run_timestamp_seconds",
+		"Unix time of the plan's next scheduled invest.", "plan")
+	investLastSuccess = metrics.NewGauge("aip_invest_last_success_timestamp_seconds",
+		"Unix time of the plan's last successful invest, restored from the last order on start.", "plan")
+	monitorLastSuccess = metrics.NewGauge("aip_monitor_last_success_timestamp_seconds",
+		"Unix time of the plan's last successful monitor run.", "plan")
+)
+
+// planGauges 需要在每次抓取时重新收集的指标
+var planGauges = []*metrics.Gauge{planPosition, planInvestment, planPrice, planEquity,
+	planPaused, planNextRun, investLastSuccess, monitorLastSuccess}
+
+// collect 从运行中的计划收集状态指标后输出全部指标。收集与输出在同一把锁内完成，
+// 同时抓取时不会输出另一次抓取清空或只收集了一半的指标
+func (d *daemon) collect(out io.Writer) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, g := range planGauges {
+		g.Reset()
+	}
+
+	for name, w := range d.workers {
+		if w.standby {
+			continue
+		}
+		st := w.plan.State()
+		symbol := w.config.Symbol
+		planPosition.Set(st.Position, name, symbol)
+		planInvestment.Set(st.Investment, name, symbol)
+		planPrice.Set(st.Price, name, symbol)
+		planEquity.Set(st.Equity, name, symbol)
+
+		paused := 0.0
+		if d.paused[name] {
+			paused = 1
+		}
+		planPaused.Set(paused, name)
+
+		if next := w.plan.Period().Next(time.Now().In(d.loc)); !next.IsZero() {
+			planNextRun.Set(float64(next.Unix()), name)
+		}
+		if st.Invested > 0 {
+			investLastSuccess.Set(float64(st.Invested), name)
+		}
+		if st.Monitored > 0 {
+			monitorLastSuccess.Set(float64(st.Monitored), name)
+		}
+	}
+
+	if err := metrics.Default.Write(out); err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+
+	return nil
+}
+
+// metrics 以 Prometheus 文本格式输出全部指标，先写入缓冲区，避免持锁写入较慢的连接
+func (s *server) metrics(w http.ResponseWriter, r *http.Request) {
+	var b bytes.Buffer
+	if err := s.d.collect(&b); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", metrics.ContentType)
+	w.Write(b.Bytes())
+}
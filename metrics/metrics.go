@@ -0,0 +1,320 @@
+// Package metrics 以 Prometheus 文本格式导出计数器、仪表及直方图指标
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/modood/aip/util"
+
+	"github.com/pkg/errors"
+)
+
+// ContentType Prometheus 文本格式的内容类型
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultBuckets 默认的直方图区间（秒），适用于接口延迟
+var DefaultBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Registry 指标注册表，按注册顺序输出
+type Registry struct {
+	mu       sync.Mutex
+	families []*family
+}
+
+// Default 默认注册表
+var Default = &Registry{}
+
+// family 同名指标，按标签值区分序列
+type family struct {
+	name    string
+	help    string
+	typ     string
+	labels  []string
+	buckets []float64 // 直方图区间上限，升序
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+// series 一组标签值对应的序列
+type series struct {
+	values []string
+	value  float64  // 计数器及仪表的值
+	counts []uint64 // 直方图各区间的计数，不累计
+	sum    float64  // 直方图观测值之和
+	count  uint64   // 直方图观测次数
+}
+
+// Counter 只增不减的计数器
+type Counter struct{ f *family }
+
+// Gauge 可任意设置的仪表
+type Gauge struct{ f *family }
+
+// Histogram 按区间统计观测值的直方图
+type Histogram struct{ f *family }
+
+// NewCounter 在默认注册表中新建计数器
+func NewCounter(name, help string, labels ...string) *Counter {
+	return Default.NewCounter(name, help, labels...)
+}
+
+// NewGauge 在默认注册表中新建仪表
+func NewGauge(name, help string, labels ...string) *Gauge {
+	return Default.NewGauge(name, help, labels...)
+}
+
+// NewHistogram 在默认注册表中新建直方图，buckets 为空时使用 DefaultBuckets
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	return Default.NewHistogram(name, help, buckets, labels...)
+}
+
+// NewCounter 新建计数器
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	return &Counter{r.register(name, help, "counter", labels, nil)}
+}
+
+// NewGauge 新建仪表
+func (r *Registry) NewGauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{r.register(name, help, "gauge", labels, nil)}
+}
+
+// NewHistogram 新建直方图，buckets 为空时使用 DefaultBuckets
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	return &Histogram{r.register(name, help, "histogram", labels, buckets)}
+}
+
+// register 注册指标，重名属于编程错误
+func (r *Registry) register(name, help, typ string, labels []string, buckets []float64) *family {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, f := range r.families {
+		if f.name == name {
+			panic("metrics: duplicate metric " + name)
+		}
+	}
+
+	f := &family{name: name, help: help, typ: typ, labels: labels, buckets: buckets,
+		series: make(map[string]*series)}
+	r.families = append(r.families, f)
+
+	return f
+}
+
+// Inc 计数加一
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add 计数增加 v，v 不能为负数
+func (c *Counter) Add(v float64, values ...string) {
+	if v < 0 {
+		panic("metrics: counter " + c.f.name + " cannot decrease")
+	}
+	c.f.with(values, func(s *series) { s.value += v })
+}
+
+// Value 返回计数，用于测试
+func (c *Counter) Value(values ...string) float64 {
+	return c.f.value(values)
+}
+
+// Set 设置仪表的值
+func (g *Gauge) Set(v float64, values ...string) {
+	g.f.with(values, func(s *series) { s.value = v })
+}
+
+// Value 返回仪表的值，用于测试
+func (g *Gauge) Value(values ...string) float64 {
+	return g.f.value(values)
+}
+
+// Delete 删除一组标签值对应的序列，如计划已删除
+func (g *Gauge) Delete(values ...string) {
+	g.f.mu.Lock()
+	defer g.f.mu.Unlock()
+	delete(g.f.series, g.f.key(values))
+}
+
+// Reset 删除全部序列
+func (g *Gauge) Reset() {
+	g.f.mu.Lock()
+	defer g.f.mu.Unlock()
+	g.f.series = make(map[string]*series)
+}
+
+// Observe 记录一次观测值
+func (h *Histogram) Observe(v float64, values ...string) {
+	h.f.with(values, func(s *series) {
+		if s.counts == nil {
+			s.counts = make([]uint64, len(h.f.buckets))
+		}
+		if i := sort.SearchFloat64s(h.f.buckets, v); i < len(h.f.buckets) {
+			s.counts[i]++
+		}
+		s.sum += v
+		s.count++
+	})
+}
+
+// Count 返回观测次数，用于测试
+func (h *Histogram) Count(values ...string) uint64 {
+	h.f.mu.Lock()
+	defer h.f.mu.Unlock()
+	if s, ok := h.f.series[h.f.key(values)]; ok {
+		return s.count
+	}
+	return 0
+}
+
+// key 标签值的唯一键，标签值个数与标签不符属于编程错误
+func (f *family) key(values []string) string {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", f.name, len(f.labels), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+// with 在锁内修改序列，不存在时新建
+func (f *family) with(values []string, fn func(*series)) {
+	k := f.key(values)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	s, ok := f.series[k]
+	if !ok {
+		s = &series{values: append([]string(nil), values...)}
+		f.series[k] = s
+	}
+	fn(s)
+}
+
+// value 返回序列的值，不存在时为 0
+func (f *family) value(values []string) float64 {
+	k := f.key(values)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if s, ok := f.series[k]; ok {
+		return s.value
+	}
+	return 0
+}
+
+// write 输出同名指标，序列按标签值排序；没有序列时不输出
+func (f *family) write(w *bufio.Writer) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(f.series) == 0 {
+		return
+	}
+
+	keys := make([]string, 0, len(f.series))
+	for k := range f.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, escape(f.help, false))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.typ)
+
+	for _, k := range keys {
+		s := f.series[k]
+		if f.typ != "histogram" {
+			fmt.Fprintf(w, "%s%s %s\n", f.name, f.labelset(s.values, ""), format(s.value))
+			continue
+		}
+
+		var cumulative uint64
+		for i, upper := range f.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, f.labelset(s.values, format(upper)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, f.labelset(s.values, "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", f.name, f.labelset(s.values, ""), format(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", f.name, f.labelset(s.values, ""), s.count)
+	}
+}
+
+// labelset 格式化标签，le 不为空时追加直方图区间标签
+func (f *family) labelset(values []string, le string) string {
+	var pairs []string
+	for i, l := range f.labels {
+		pairs = append(pairs, l+`="`+escape(values[i], true)+`"`)
+	}
+	if le != "" {
+		pairs = append(pairs, `le="`+le+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// Write 以文本格式输出全部指标
+func (r *Registry) Write(out io.Writer) error {
+	r.mu.Lock()
+	families := append([]*family(nil), r.families...)
+	r.mu.Unlock()
+
+	w := bufio.NewWriter(out)
+	for _, f := range families {
+		f.write(w)
+	}
+
+	if err := w.Flush(); err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+
+	return nil
+}
+
+// Handler 返回输出全部指标的 HTTP 处理器
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", ContentType)
+		if err := r.Write(w); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
+
+// escape 转义帮助文本或标签值
+func escape(s string, quote bool) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, "\n", `\n`, -1)
+	if quote {
+		s = strings.Replace(s, `"`, `\"`, -1)
+	}
+	return s
+}
+
+// format 格式化样本值
+func format(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,65 @@
+package metrics
+
+import (
+	"bytes"
+	"math"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRegistry(t *testing.T) {
+	Convey("should write counters, gauges and histograms in text format", t, func() {
+		r := &Registry{}
+		c := r.NewCounter("aip_test_total", "Test counter.", "plan", "class")
+		g := r.NewGauge("aip_test_gauge", "Test gauge\nwith \\ newline.", "plan")
+		h := r.NewHistogram("aip_test_seconds", "Test histogram.", []float64{1, 0.1}, "endpoint")
+		r.NewGauge("aip_test_empty", "Not written without series.")
+
+		c.Inc("b", "network")
+		c.Add(2, "a", `quote"d`)
+		g.Set(6400.5, "a")
+		g.Set(math.Inf(1), "b")
+		h.Observe(0.05, "/v1/order")
+		h.Observe(0.5, "/v1/order")
+		h.Observe(3, "/v1/order")
+
+		So(c.Value("a", `quote"d`), ShouldEqual, 2)
+		So(g.Value("a"), ShouldEqual, 6400.5)
+		So(h.Count("/v1/order"), ShouldEqual, 3)
+		So(func() { c.Inc("a") }, ShouldPanic)
+		So(func() { c.Add(-1, "a", "b") }, ShouldPanic)
+		So(func() { r.NewCounter("aip_test_total", "") }, ShouldPanic)
+
+		var b bytes.Buffer
+		So(r.Write(&b), ShouldBeNil)
+		So(b.String(), ShouldEqual, `# HELP aip_test_total Test counter.
+# TYPE aip_test_total counter
+aip_test_total{plan="a",class="quote\"d"} 2
+aip_test_total{plan="b",class="network"} 1
+# HELP aip_test_gauge Test gauge\nwith \\ newline.
+# TYPE aip_test_gauge gauge
+aip_test_gauge{plan="a"} 6400.5
+aip_test_gauge{plan="b"} +Inf
+# HELP aip_test_seconds Test histogram.
+# TYPE aip_test_seconds histogram
+aip_test_seconds_bucket{endpoint="/v1/order",le="0.1"} 1
+aip_test_seconds_bucket{endpoint="/v1/order",le="1"} 2
+aip_test_seconds_bucket{endpoint="/v1/order",le="+Inf"} 3
+aip_test_seconds_sum{endpoint="/v1/order"} 3.55
+aip_test_seconds_count{endpoint="/v1/order"} 3
+`)
+
+		g.Delete("b")
+		So(g.Value("b"), ShouldEqual, 0)
+		g.Reset()
+		So(g.Value("a"), ShouldEqual, 0)
+
+		w := httptest.NewRecorder()
+		r.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+		So(w.Header().Get("Content-Type"), ShouldEqual, ContentType)
+		So(w.Body.String(), ShouldNotContainSubstring, "aip_test_gauge")
+		So(w.Body.String(), ShouldContainSubstring, "aip_test_total")
+	})
+}
@@ -0,0 +1,90 @@
+package notify
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/modood/aip/util"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/pkg/errors"
+)
+
+var (
+	errInvalidWebhook = errors.New("webhook must be an absolute http(s) url")
+
+	json = jsoniter.ConfigCompatibleWithStandardLibrary
+)
+
+// 事件类型
+const (
+	Invested = "invested" // 投资成功
+	Failed   = "failed"   // 投资失败
+	Missed   = "missed"   // 错过周期
+)
+
+// Event 通知事件
+type Event struct {
+	Plan    string    `json:"plan"`    // 定投计划
+	Symbol  string    `json:"symbol"`  // 交易品种
+	Type    string    `json:"type"`    // 事件类型
+	Message string    `json:"message"` // 事件描述
+	Time    time.Time `json:"time"`    // 事件时间
+}
+
+// Notifier 通知接口定义
+type Notifier interface {
+	Notify(e *Event) error // 发送通知
+}
+
+// Webhook 以 JSON 格式将事件 POST 到指定地址
+type Webhook struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhook 创建 webhook 通知
+func NewWebhook(address string) (Notifier, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, errors.Wrap(err, util.FuncName())
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, errors.Wrap(errInvalidWebhook, util.FuncName())
+	}
+
+	return &Webhook{
+		url:    address,
+		client: &http.Client{Timeout: time.Duration(time.Second * 5)},
+	}, nil
+}
+
+// Notify 发送通知
+func (w *Webhook) Notify(e *Event) error {
+	bs, err := json.Marshal(e)
+	if err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+
+	resp, err := w.client.Post(w.url, "application/json", bytes.NewBuffer(bs))
+	if err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			log.Println(err)
+		}
+	}()
+
+	if resp.StatusCode/100 != 2 {
+		err = fmt.Errorf("webhook responded with status %s", resp.Status)
+		return errors.Wrap(err, util.FuncName())
+	}
+
+	return nil
+}
@@ -0,0 +1,40 @@
+package notify
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestWebhook(t *testing.T) {
+	Convey("should post event as json", t, func() {
+		var body []byte
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ = ioutil.ReadAll(r.Body)
+		}))
+		defer srv.Close()
+
+		n, err := NewWebhook(srv.URL)
+		So(err, ShouldBeNil)
+
+		err = n.Notify(&Event{
+			Plan:    "default",
+			Symbol:  "btcusdt",
+			Type:    Invested,
+			Message: "bought 0.0015 btc",
+			Time:    time.Date(2018, time.September, 8, 0, 0, 0, 0, time.UTC),
+		})
+		So(err, ShouldBeNil)
+		So(string(body), ShouldContainSubstring, `"plan":"default"`)
+		So(string(body), ShouldContainSubstring, `"type":"invested"`)
+	})
+
+	Convey("should reject relative url", t, func() {
+		_, err := NewWebhook("/hooks/aip")
+		So(err, ShouldNotBeNil)
+	})
+}
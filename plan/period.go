@@ -2,17 +2,38 @@ package plan
 
 import (
 	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/modood/aip/util"
 
 	"github.com/pkg/errors"
+	"github.com/robfig/cron"
 )
 
+var (
+	errInvalidCron     = errors.New("cron expression must have 6 fields")
+	errInvalidInterval = errors.New("interval must be at least one second")
+	errUnevenInterval  = errors.New("interval must divide 24h or be a whole number of days")
+	errInvalidWeekday  = errors.New("invalid weekday")
+	errInvalidClock    = errors.New("invalid time of day, expect hh:mm[:ss]")
+	errInvalidWindow   = errors.New("window must be at least one second and shorter than the period")
+)
+
+// day 一天的时长，固定间隔周期按天对齐
+const day = 24 * time.Hour
+
+// epochMonday 双周周期的参考周（1970-01-05 为周一）
+var epochMonday = time.Date(1970, time.January, 5, 0, 0, 0, 0, time.UTC)
+
 // Period 周期接口定义
 type Period interface {
 	// Schedule 获取 cron 表达式
 	Schedule() string
+	// Next 获取晚于 t 的下一次执行时间，按 t 所在时区计算
+	Next(t time.Time) time.Time
 }
 
 type datetime struct {
@@ -46,6 +67,15 @@ func (d *Daily) Schedule() string {
 	return fmt.Sprintf("%d %d %d * * *", d.second, d.minute, d.hour)
 }
 
+// Next 获取晚于 t 的下一次执行时间
+func (d *Daily) Next(t time.Time) time.Time {
+	n := d.datetime.on(t)
+	if !n.After(t) {
+		n = d.datetime.on(t.AddDate(0, 0, 1))
+	}
+	return n
+}
+
 // Weekly 按周
 type Weekly struct {
 	datetime
@@ -73,14 +103,22 @@ func (w *Weekly) Schedule() string {
 	return fmt.Sprintf("%d %d %d * * %d", w.second, w.minute, w.hour, w.weekday)
 }
 
+// Next 获取晚于 t 的下一次执行时间
+func (w *Weekly) Next(t time.Time) time.Time {
+	return w.datetime.nextWeekday(t, w.weekday)
+}
+
 // Monthly 按月
 type Monthly struct {
 	datetime
 	day uint8
 }
 
-// NewMonthly 创建按月周期
+// NewMonthly 创建按月周期，当月没有该日（如 2 月 30 日）时在月末执行
 func NewMonthly(day, hour, minute, second uint8) (Period, error) {
+	if err := mustBetween(day, 1, 31); err != nil {
+		return nil, errors.Wrap(errors.Wrap(err, "invalid day"), util.FuncName())
+	}
 	if err := validateTime(hour, minute, second); err != nil {
 		return nil, errors.Wrap(err, util.FuncName())
 	}
@@ -95,11 +133,307 @@ func NewMonthly(day, hour, minute, second uint8) (Period, error) {
 	}, nil
 }
 
-// Schedule 获取 cron 表达式
+// Schedule 获取 cron 表达式（29-31 日在小月的月末回退无法用 cron 表达）
 func (m *Monthly) Schedule() string {
 	return fmt.Sprintf("%d %d %d %d * *", m.second, m.minute, m.hour, m.day)
 }
 
+// Next 获取晚于 t 的下一次执行时间
+func (m *Monthly) Next(t time.Time) time.Time {
+	y, mon, _ := t.Date()
+	for i := 0; ; i++ {
+		n := m.datetime.on(dayOfMonth(y, mon+time.Month(i), int(m.day), t.Location()))
+		if n.After(t) {
+			return n
+		}
+	}
+}
+
+// Biweekly 按双周
+type Biweekly struct {
+	datetime
+	weekday time.Weekday
+}
+
+// NewBiweekly 创建按双周周期，以 1970-01-05 所在周为第一周，每隔一周执行一次
+func NewBiweekly(weekday time.Weekday, hour, minute, second uint8) (Period, error) {
+	if err := validateTime(hour, minute, second); err != nil {
+		return nil, errors.Wrap(err, util.FuncName())
+	}
+
+	return &Biweekly{
+		weekday: weekday,
+		datetime: datetime{
+			hour:   hour,
+			minute: minute,
+			second: second,
+		},
+	}, nil
+}
+
+// Schedule 获取 cron 表达式（cron 无法表达双周，仅描述每周的执行时刻）
+func (b *Biweekly) Schedule() string {
+	return fmt.Sprintf("%d %d %d * * %d", b.second, b.minute, b.hour, b.weekday)
+}
+
+// Next 获取晚于 t 的下一次执行时间
+func (b *Biweekly) Next(t time.Time) time.Time {
+	n := b.datetime.nextWeekday(t, b.weekday)
+
+	y, m, d := n.Date()
+	days := time.Date(y, m, d, 0, 0, 0, 0, time.UTC).Sub(epochMonday) / day
+	if (days/7)%2 != 0 {
+		n = b.datetime.on(n.AddDate(0, 0, 7))
+	}
+
+	return n
+}
+
+// MonthEnd 按月，每月最后一天
+type MonthEnd struct {
+	datetime
+}
+
+// NewMonthEnd 创建按月周期，每月最后一天执行
+func NewMonthEnd(hour, minute, second uint8) (Period, error) {
+	if err := validateTime(hour, minute, second); err != nil {
+		return nil, errors.Wrap(err, util.FuncName())
+	}
+
+	return &MonthEnd{
+		datetime: datetime{
+			hour:   hour,
+			minute: minute,
+			second: second,
+		},
+	}, nil
+}
+
+// Schedule 获取 cron 表达式（cron 无法表达月末，以 28-31 日描述）
+func (m *MonthEnd) Schedule() string {
+	return fmt.Sprintf("%d %d %d 28-31 * *", m.second, m.minute, m.hour)
+}
+
+// Next 获取晚于 t 的下一次执行时间
+func (m *MonthEnd) Next(t time.Time) time.Time {
+	y, mon, _ := t.Date()
+	n := m.datetime.on(dayOfMonth(y, mon, 31, t.Location()))
+	if !n.After(t) {
+		n = m.datetime.on(dayOfMonth(y, mon+1, 31, t.Location()))
+	}
+	return n
+}
+
+// Cron 自定义 cron 表达式
+type Cron struct {
+	spec     string
+	schedule cron.Schedule
+}
+
+// NewCron 创建自定义周期，spec 为 6 段式 cron 表达式：秒 分 时 日 月 周
+func NewCron(spec string) (Period, error) {
+	if len(strings.Fields(spec)) != 6 {
+		return nil, errors.Wrap(errInvalidCron, util.FuncName())
+	}
+
+	schedule, err := cron.Parse(spec)
+	if err != nil {
+		return nil, errors.Wrap(err, util.FuncName())
+	}
+
+	return &Cron{
+		spec:     spec,
+		schedule: schedule,
+	}, nil
+}
+
+// Schedule 获取 cron 表达式
+func (c *Cron) Schedule() string {
+	return c.spec
+}
+
+// Next 获取晚于 t 的下一次执行时间
+func (c *Cron) Next(t time.Time) time.Time {
+	return c.schedule.Next(t)
+}
+
+// Every 固定间隔
+type Every struct {
+	interval time.Duration
+}
+
+// NewEvery 创建固定间隔周期，执行时刻按所在时区的钟点从当天零点起对齐
+// （如 1h 总在整点执行，24h 总在零点执行）。间隔需能整除 24h 或为整天数，
+// 否则跨过零点时间隔不均匀
+func NewEvery(interval time.Duration) (Period, error) {
+	if interval < time.Second {
+		return nil, errors.Wrap(errInvalidInterval, util.FuncName())
+	}
+
+	interval = interval.Truncate(time.Second)
+	if interval < day && day%interval != 0 || interval > day && interval%day != 0 {
+		return nil, errors.Wrap(errUnevenInterval, util.FuncName())
+	}
+
+	return &Every{
+		interval: interval,
+	}, nil
+}
+
+// Schedule 获取 cron 表达式
+func (e *Every) Schedule() string {
+	return "@every " + e.interval.String()
+}
+
+// Next 获取晚于 t 的下一次执行时间，按 t 所在时区的钟点计算，夏令时切换当天不会重复或提前执行
+func (e *Every) Next(t time.Time) time.Time {
+	year, month, date := t.Date()
+	if e.interval >= day {
+		return time.Date(year, month, date+int(e.interval/day), 0, 0, 0, 0, t.Location())
+	}
+
+	// 当天零点起的钟点秒数，不受夏令时切换影响
+	step := int(e.interval / time.Second)
+	clock := t.Hour()*3600 + t.Minute()*60 + t.Second()
+	for slot := (clock/step + 1) * step; ; slot += step {
+		// 夏令时开始时跳过的钟点由 time.Date 顺延，仍早于 t 时取下一个
+		if next := time.Date(year, month, date, 0, 0, slot, 0, t.Location()); next.After(t) {
+			return next
+		}
+	}
+}
+
+// Window 执行窗口，在周期原定执行时刻之后的窗口内随机选择执行时间，
+// 随机偏移由种子和原定执行时刻决定，重启后同一周期的执行时间不变
+type Window struct {
+	Period
+	window time.Duration
+	seed   string
+}
+
+// NewWindow 为周期增加执行窗口，如按日 00:00 加 2h 窗口即在 00:00-02:00 之间执行
+func NewWindow(period Period, window time.Duration, seed string) (Period, error) {
+	window = window.Truncate(time.Second)
+	if window < time.Second {
+		return nil, errors.Wrap(errInvalidWindow, util.FuncName())
+	}
+
+	// 窗口不能超过相邻两次执行的间隔，否则执行顺序会错乱
+	prev := period.Next(time.Now())
+	for _, t := range Upcoming(period, prev, 16) {
+		if t.Sub(prev) <= window {
+			return nil, errors.Wrap(errInvalidWindow, util.FuncName())
+		}
+		prev = t
+	}
+
+	return &Window{
+		Period: period,
+		window: window,
+		seed:   seed,
+	}, nil
+}
+
+// Next 获取晚于 t 的下一次执行时间
+func (w *Window) Next(t time.Time) time.Time {
+	b := w.Period.Next(t.Add(-w.window))
+	for !b.IsZero() {
+		if n := b.Add(w.offset(b)); n.After(t) {
+			return n
+		}
+		b = w.Period.Next(b)
+	}
+	return b
+}
+
+// offset 返回原定执行时刻 b 在窗口内的随机偏移
+func (w *Window) offset(b time.Time) time.Duration {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%s/%d", w.seed, b.Unix())
+	return time.Duration(h.Sum64()%uint64(w.window/time.Second)) * time.Second
+}
+
+// on 返回 t 所在日期的执行时刻
+func (d datetime) on(t time.Time) time.Time {
+	y, m, day := t.Date()
+	return time.Date(y, m, day,
+		int(d.hour), int(d.minute), int(d.second), 0, t.Location())
+}
+
+// nextWeekday 返回晚于 t 且在指定星期的执行时刻
+func (d datetime) nextWeekday(t time.Time, weekday time.Weekday) time.Time {
+	n := d.on(t)
+	for !n.After(t) || n.Weekday() != weekday {
+		n = d.on(n.AddDate(0, 0, 1))
+	}
+	return n
+}
+
+// dayOfMonth 返回指定月份的第 day 日，超出当月天数时返回月末
+func dayOfMonth(year int, month time.Month, day int, loc *time.Location) time.Time {
+	last := time.Date(year, month+1, 0, 0, 0, 0, 0, loc)
+	if day > last.Day() {
+		return last
+	}
+	return time.Date(year, month, day, 0, 0, 0, 0, loc)
+}
+
+// Upcoming 返回晚于 t 的 n 次执行时间
+func Upcoming(p Period, t time.Time, n int) []time.Time {
+	r := make([]time.Time, 0, n)
+	for i := 0; i < n; i++ {
+		t = p.Next(t)
+		if t.IsZero() {
+			break
+		}
+		r = append(r, t)
+	}
+	return r
+}
+
+// ParseWeekday 解析星期，支持英文全称、缩写及数字 0-6（0 为周日）
+func ParseWeekday(s string) (time.Weekday, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if i, err := strconv.Atoi(s); err == nil {
+		if i < 0 || i > 6 {
+			return 0, errors.Wrap(errInvalidWeekday, util.FuncName())
+		}
+		return time.Weekday(i), nil
+	}
+
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		name := strings.ToLower(d.String())
+		if s == name || s == name[:3] {
+			return d, nil
+		}
+	}
+
+	return 0, errors.Wrap(errInvalidWeekday, util.FuncName())
+}
+
+// ParseClock 解析一天中的时刻，格式为 hh:mm 或 hh:mm:ss
+func ParseClock(s string) (hour, minute, second uint8, err error) {
+	parts := strings.Split(strings.TrimSpace(s), ":")
+	if len(parts) != 2 && len(parts) != 3 {
+		return 0, 0, 0, errors.Wrap(errInvalidClock, util.FuncName())
+	}
+
+	var v [3]uint8
+	for i, part := range parts {
+		n, err := strconv.ParseUint(part, 10, 8)
+		if err != nil {
+			return 0, 0, 0, errors.Wrap(errInvalidClock, util.FuncName())
+		}
+		v[i] = uint8(n)
+	}
+
+	if err = validateTime(v[0], v[1], v[2]); err != nil {
+		return 0, 0, 0, errors.Wrap(err, util.FuncName())
+	}
+
+	return v[0], v[1], v[2], nil
+}
+
 func validateTime(hour, minute, second uint8) error {
 	var err error
 	if err = mustBetween(hour, 0, 23); err != nil {
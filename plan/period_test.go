@@ -15,6 +15,17 @@ func TestDaily(t *testing.T) {
 		r := p.Schedule()
 		So(r, ShouldEqual, "34 56 7 * * *")
 	})
+
+	Convey("should return next execution time", t, func() {
+		p, err := NewDaily(7, 56, 34)
+		So(err, ShouldBeNil)
+
+		n := p.Next(time.Date(2018, time.September, 1, 7, 56, 34, 0, time.UTC))
+		So(n, ShouldEqual, time.Date(2018, time.September, 2, 7, 56, 34, 0, time.UTC))
+
+		n = p.Next(time.Date(2018, time.September, 1, 0, 0, 0, 0, time.UTC))
+		So(n, ShouldEqual, time.Date(2018, time.September, 1, 7, 56, 34, 0, time.UTC))
+	})
 }
 
 func TestWeekly(t *testing.T) {
@@ -25,6 +36,14 @@ func TestWeekly(t *testing.T) {
 		r := p.Schedule()
 		So(r, ShouldEqual, "34 56 7 * * 6")
 	})
+
+	Convey("should return next execution time", t, func() {
+		p, err := NewWeekly(time.Saturday, 7, 56, 34)
+		So(err, ShouldBeNil)
+
+		n := p.Next(time.Date(2018, time.September, 1, 8, 0, 0, 0, time.UTC))
+		So(n, ShouldEqual, time.Date(2018, time.September, 8, 7, 56, 34, 0, time.UTC))
+	})
 }
 
 func TestMonthly(t *testing.T) {
@@ -35,4 +54,203 @@ func TestMonthly(t *testing.T) {
 		r := p.Schedule()
 		So(r, ShouldEqual, "34 56 7 1 * *")
 	})
+
+	Convey("should fall back to month end when the day does not exist", t, func() {
+		p, err := NewMonthly(31, 0, 0, 0)
+		So(err, ShouldBeNil)
+
+		r := Upcoming(p, time.Date(2018, time.January, 31, 0, 0, 0, 0, time.UTC), 3)
+		So(r, ShouldResemble, []time.Time{
+			time.Date(2018, time.February, 28, 0, 0, 0, 0, time.UTC),
+			time.Date(2018, time.March, 31, 0, 0, 0, 0, time.UTC),
+			time.Date(2018, time.April, 30, 0, 0, 0, 0, time.UTC),
+		})
+	})
+
+	Convey("should reject invalid day", t, func() {
+		_, err := NewMonthly(0, 0, 0, 0)
+		So(err, ShouldNotBeNil)
+
+		_, err = NewMonthly(32, 0, 0, 0)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestBiweekly(t *testing.T) {
+	Convey("should new biweekly successfully", t, func() {
+		p, err := NewBiweekly(time.Saturday, 7, 56, 34)
+		So(err, ShouldBeNil)
+
+		r := p.Schedule()
+		So(r, ShouldEqual, "34 56 7 * * 6")
+	})
+
+	Convey("should fire every other week", t, func() {
+		p, err := NewBiweekly(time.Monday, 8, 0, 0)
+		So(err, ShouldBeNil)
+
+		n := p.Next(time.Date(2018, time.September, 1, 0, 0, 0, 0, time.UTC))
+		So(n, ShouldEqual, time.Date(2018, time.September, 10, 8, 0, 0, 0, time.UTC))
+
+		n = p.Next(n)
+		So(n, ShouldEqual, time.Date(2018, time.September, 24, 8, 0, 0, 0, time.UTC))
+	})
+}
+
+func TestMonthEnd(t *testing.T) {
+	Convey("should fire on the last day of month", t, func() {
+		p, err := NewMonthEnd(23, 0, 0)
+		So(err, ShouldBeNil)
+
+		n := p.Next(time.Date(2018, time.February, 10, 0, 0, 0, 0, time.UTC))
+		So(n, ShouldEqual, time.Date(2018, time.February, 28, 23, 0, 0, 0, time.UTC))
+
+		n = p.Next(n)
+		So(n, ShouldEqual, time.Date(2018, time.March, 31, 23, 0, 0, 0, time.UTC))
+	})
+}
+
+func TestCron(t *testing.T) {
+	Convey("should new cron successfully", t, func() {
+		p, err := NewCron("0 30 9 * * 1-5")
+		So(err, ShouldBeNil)
+
+		r := p.Schedule()
+		So(r, ShouldEqual, "0 30 9 * * 1-5")
+	})
+
+	Convey("should reject expression without 6 fields", t, func() {
+		_, err := NewCron("30 9 * * *")
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestEvery(t *testing.T) {
+	Convey("should new every successfully", t, func() {
+		p, err := NewEvery(6 * time.Hour)
+		So(err, ShouldBeNil)
+		So(p.Schedule(), ShouldEqual, "@every 6h0m0s")
+
+		n := p.Next(time.Date(2018, time.September, 1, 7, 30, 0, 0, time.UTC))
+		So(n, ShouldEqual, time.Date(2018, time.September, 1, 12, 0, 0, 0, time.UTC))
+	})
+
+	Convey("should align to the local midnight", t, func() {
+		loc, err := time.LoadLocation("Asia/Kolkata") // UTC+05:30
+		So(err, ShouldBeNil)
+
+		p, err := NewEvery(time.Hour)
+		So(err, ShouldBeNil)
+		n := p.Next(time.Date(2018, time.September, 1, 7, 10, 0, 0, loc))
+		So(n, ShouldEqual, time.Date(2018, time.September, 1, 8, 0, 0, 0, loc))
+
+		p, err = NewEvery(24 * time.Hour)
+		So(err, ShouldBeNil)
+		n = p.Next(time.Date(2018, time.September, 1, 0, 0, 0, 0, loc))
+		So(n, ShouldEqual, time.Date(2018, time.September, 2, 0, 0, 0, 0, loc))
+		n = p.Next(time.Date(2018, time.September, 1, 23, 59, 0, 0, loc))
+		So(n, ShouldEqual, time.Date(2018, time.September, 2, 0, 0, 0, 0, loc))
+	})
+
+	Convey("should follow the wall clock across daylight saving transitions", t, func() {
+		loc, err := time.LoadLocation("Europe/Berlin")
+		So(err, ShouldBeNil)
+
+		// 2018-10-28 03:00 CEST 回拨到 02:00 CET，当天有 25 小时
+		p, err := NewEvery(24 * time.Hour)
+		So(err, ShouldBeNil)
+		n := p.Next(time.Date(2018, time.October, 28, 0, 0, 0, 0, loc))
+		So(n, ShouldEqual, time.Date(2018, time.October, 29, 0, 0, 0, 0, loc))
+		n = p.Next(time.Date(2018, time.October, 29, 23, 0, 0, 0, loc))
+		So(n, ShouldEqual, time.Date(2018, time.October, 30, 0, 0, 0, 0, loc))
+
+		p, err = NewEvery(time.Hour)
+		So(err, ShouldBeNil)
+		cest := time.Date(2018, time.October, 28, 0, 30, 0, 0, time.UTC).In(loc) // 02:30 CEST
+		n = p.Next(cest)
+		So(n.Sub(cest), ShouldEqual, 90*time.Minute) // 03:00 CET，不重复回拨的钟点
+		So(n.Hour(), ShouldEqual, 3)
+
+		// 2018-03-25 02:00 CET 跳到 03:00 CEST
+		n = p.Next(time.Date(2018, time.March, 25, 1, 30, 0, 0, loc))
+		So(n, ShouldEqual, time.Date(2018, time.March, 25, 3, 0, 0, 0, loc))
+		So(p.Next(n), ShouldEqual, time.Date(2018, time.March, 25, 4, 0, 0, 0, loc))
+	})
+
+	Convey("should reject interval less than one second", t, func() {
+		_, err := NewEvery(time.Millisecond)
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("should reject interval that does not divide a day", t, func() {
+		_, err := NewEvery(7 * time.Hour)
+		So(err, ShouldNotBeNil)
+		_, err = NewEvery(36 * time.Hour)
+		So(err, ShouldNotBeNil)
+		_, err = NewEvery(48 * time.Hour)
+		So(err, ShouldBeNil)
+	})
+}
+
+func TestParseWeekday(t *testing.T) {
+	Convey("should parse weekday successfully", t, func() {
+		for _, s := range []string{"friday", "Fri", "5"} {
+			d, err := ParseWeekday(s)
+			So(err, ShouldBeNil)
+			So(d, ShouldEqual, time.Friday)
+		}
+
+		_, err := ParseWeekday("7")
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestParseClock(t *testing.T) {
+	Convey("should parse time of day successfully", t, func() {
+		h, m, s, err := ParseClock("07:56:34")
+		So(err, ShouldBeNil)
+		So([]uint8{h, m, s}, ShouldResemble, []uint8{7, 56, 34})
+
+		h, m, s, err = ParseClock("18:30")
+		So(err, ShouldBeNil)
+		So([]uint8{h, m, s}, ShouldResemble, []uint8{18, 30, 0})
+
+		_, _, _, err = ParseClock("24:00")
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestWindow(t *testing.T) {
+	Convey("should fire at a stable moment within the window", t, func() {
+		daily, err := NewDaily(0, 0, 0)
+		So(err, ShouldBeNil)
+
+		p, err := NewWindow(daily, 2*time.Hour, "btcusdt")
+		So(err, ShouldBeNil)
+
+		start := time.Date(2018, time.September, 1, 0, 0, 0, 0, time.UTC)
+		r := Upcoming(p, start, 5)
+		So(len(r), ShouldEqual, 5)
+		for i, n := range r {
+			base := start.AddDate(0, 0, i)
+			So(n, ShouldHappenOnOrAfter, base)
+			So(n, ShouldHappenBefore, base.Add(2*time.Hour))
+
+			// 在窗口内重新计算，本期执行时间不变
+			So(p.Next(base), ShouldEqual, n)
+			So(p.Next(n), ShouldHappenAfter, base.Add(2*time.Hour))
+		}
+
+		other, err := NewWindow(daily, 2*time.Hour, "btcusdt")
+		So(err, ShouldBeNil)
+		So(Upcoming(other, start, 5), ShouldResemble, r)
+	})
+
+	Convey("should reject window longer than the period", t, func() {
+		daily, err := NewDaily(0, 0, 0)
+		So(err, ShouldBeNil)
+
+		_, err = NewWindow(daily, 25*time.Hour, "btcusdt")
+		So(err, ShouldNotBeNil)
+	})
 }
@@ -1,10 +1,15 @@
 package plan
 
 import (
+	"fmt"
+	"log"
+	"sync"
 	"time"
 
 	"github.com/modood/aip/db"
 	"github.com/modood/aip/huobi"
+	"github.com/modood/aip/metrics"
+	"github.com/modood/aip/notify"
 	"github.com/modood/aip/util"
 
 	"github.com/pkg/errors"
@@ -12,52 +17,188 @@ import (
 
 // Plan 定投计划接口定义
 type Plan interface {
-	Period() Period // 获取定投周期
-	Invest() error  // 执行一次投资
-	Monitor() error // 执行一次监控
+	Name() string                // 获取计划名称
+	Period() Period              // 获取定投周期
+	Invest() error               // 执行一次投资
+	Execute(now time.Time) error // 执行到期的定投，并按策略处理错过的周期
+	Catchup(now time.Time) error // 按策略处理停机期间错过的周期
+	Skip(now time.Time) error    // 跳过到期的定投，如计划暂停时
+	Monitor() error              // 执行一次监控
+	State() State                // 获取最近一次更新的持仓及净值
+}
+
+// CatchupPolicy 错过周期的补投策略
+type CatchupPolicy string
+
+// 补投策略
+const (
+	CatchupSkip    CatchupPolicy = "skip"    // 跳过错过的周期
+	CatchupExecute CatchupPolicy = "execute" // 逐期补投
+	CatchupMerge   CatchupPolicy = "merge"   // 合并为一笔补投
+)
+
+// maxCatchup 最多补投的周期数，更早错过的周期直接忽略
+const maxCatchup = 1000
+
+// 投资失败的错误分类
+const (
+	ClassBalance  = "balance"  // 余额不足
+	ClassExchange = "exchange" // 交易所返回的其他错误
+	ClassNetwork  = "network"  // 网络错误或超时
+	ClassStorage  = "storage"  // 已下单但确认或记录订单失败
+	ClassOther    = "other"    // 其他错误
+)
+
+var (
+	investAttempts = metrics.NewCounter("aip_invest_attempts_total",
+		"Invest attempts, including catchups and invest now.", "plan")
+	investSuccesses = metrics.NewCounter("aip_invest_successes_total",
+		"Invests whose order was placed and recorded.", "plan")
+	investFailures = metrics.NewCounter("aip_invest_failures_total",
+		"Failed invests by error class: balance, exchange, network, storage or other.", "plan", "class")
+)
+
+// State 定投计划的持仓及净值
+type State struct {
+	Position   float64 // 持仓总额（基础货币）
+	Investment float64 // 投入总额（报价货币）
+	Price      float64 // 当前价格
+	Equity     float64 // 净值总额（报价货币）
+	Updated    uint64  // 更新时间
+	Invested   uint64  // 最近一次投资成功时间，没有订单时为 0
+	Monitored  uint64  // 最近一次监控成功时间，启动后尚未监控时为 0
 }
 
 type state struct {
-	position   float64 // 持仓总额（基础货币）
-	investment float64 // 投入总额（报价货币）
-	price      float64 // 当前价格
-	equity     float64 // 净值总额（报价货币）
-	updated    uint64  // 更新时间
+	mu         sync.Mutex // 投资、监控及查询可能同时进行
+	position   float64    // 持仓总额（基础货币）
+	investment float64    // 投入总额（报价货币）
+	price      float64    // 当前价格
+	equity     float64    // 净值总额（报价货币）
+	updated    uint64     // 更新时间
+	invested   uint64     // 最近一次投资成功时间
+	monitored  uint64     // 最近一次监控成功时间
+}
+
+// Options 定投计划参数
+type Options struct {
+	Name     string          // 计划名称
+	Symbol   string          // 交易品种
+	Amount   float64         // 每期金额
+	Period   Period          // 定投周期
+	Catchup  CatchupPolicy   // 补投策略
+	Notifier notify.Notifier // 通知，可为空
 }
 
 type plan struct {
 	state
-	client *huobi.Client // 火币客户端
-	period Period        // 定投周期
-	symbol string        // 交易品种
-	amount float64       // 每期金额
+	client   *huobi.Client   // 火币客户端
+	store    db.Store        // 数据存储
+	name     string          // 计划名称
+	period   Period          // 定投周期
+	symbol   string          // 交易品种
+	amount   float64         // 每期金额
+	catchup  CatchupPolicy   // 补投策略
+	notifier notify.Notifier // 通知
+	started  time.Time       // 启动时间
 }
 
 // addOrder 新增订单
 func (p *plan) addOrder(order *huobi.OpenOrder) error {
-	return db.AddOrder(&db.Order{
+	s, err := p.client.Symbol(order.Symbol)
+	if err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+
+	return p.store.AddOrder(Record(p.name, s, order))
+}
+
+// Record 将火币订单转换为定投计划的订单记录，卖单的成交金额记录为负数。
+// 火币买单的手续费以基础货币收取，卖单以报价货币收取
+func Record(name string, s *huobi.Symbol, order *huobi.OpenOrder) *db.Order {
+	feeCurrency := s.BaseCurrency
+	base, quote := order.FieldAmount, order.FieldCashAmount
+	if isSell(order) {
+		feeCurrency = s.QuoteCurrency
+		base, quote = -base, -quote
+	}
+
+	var price float64
+	if order.FieldAmount != 0 {
+		price = order.FieldCashAmount / order.FieldAmount
+	}
+
+	return &db.Order{
 		ID:          order.ID,
+		Plan:        name,
 		Symbol:      order.Symbol,
 		Type:        order.Type,
-		Price:       order.FieldCashAmount / order.FieldAmount,
-		BaseAmount:  order.FieldAmount,
-		QuoteAmount: order.FieldCashAmount,
+		State:       order.State,
+		Amount:      order.Amount,
+		Price:       price,
+		BaseAmount:  base,
+		QuoteAmount: quote,
+		Fees:        order.FieldFees,
+		FeeCurrency: feeCurrency,
 		Created:     order.CreatedAt / 1000,
-	})
+		Finished:    order.FinishedAt / 1000,
+	}
 }
 
 // addStatistics 新增统计
 func (p *plan) addStatistics() error {
-	return db.AddStatistics(&db.Statistics{
+	st := p.State()
+	return p.store.AddStatistics(&db.Statistics{
+		Plan:       p.name,
 		Symbol:     p.symbol,
-		Position:   p.state.position,
-		Investment: p.state.investment,
-		Price:      p.state.price,
-		Equity:     p.state.equity,
-		Created:    p.state.updated,
+		Position:   st.Position,
+		Investment: st.Investment,
+		Price:      st.Price,
+		Equity:     st.Equity,
+		Created:    st.Updated,
 	})
 }
 
+// addExecutions 新增执行记录
+func (p *plan) addExecutions(scheduled []time.Time, status string, orderID uint64, cause error) error {
+	var msg string
+	if cause != nil {
+		msg = cause.Error()
+	}
+
+	for _, t := range scheduled {
+		if err := p.store.AddExecution(&db.Execution{
+			Plan:      p.name,
+			Symbol:    p.symbol,
+			Scheduled: uint64(t.Unix()),
+			Status:    status,
+			OrderID:   orderID,
+			Error:     msg,
+		}); err != nil {
+			return errors.Wrap(err, util.FuncName())
+		}
+	}
+
+	return nil
+}
+
+// notify 发送通知，失败只记录日志
+func (p *plan) notify(typ, format string, args ...interface{}) {
+	if p.notifier == nil {
+		return
+	}
+
+	if err := p.notifier.Notify(&notify.Event{
+		Plan:    p.name,
+		Symbol:  p.symbol,
+		Type:    typ,
+		Message: fmt.Sprintf(format, args...),
+		Time:    time.Now(),
+	}); err != nil {
+		log.Println(errors.Wrap(err, util.FuncName()))
+	}
+}
+
 // stateFlush 刷新，查询最新报价刷新净值数据
 func (p *plan) stateFlush() error {
 	price, err := p.client.SymbolPrice(p.symbol)
@@ -65,23 +206,27 @@ func (p *plan) stateFlush() error {
 		return errors.Wrap(err, util.FuncName())
 	}
 
+	p.state.mu.Lock()
 	p.state.price = price
 	p.state.equity = price * p.state.position
 	p.state.updated = uint64(time.Now().Unix())
+	p.state.mu.Unlock()
 
 	return nil
 }
 
 // stateInit 初始化，将统计数据从数据库加载到内存中
 func (p *plan) stateInit() error {
-	position, investment, err := db.OrderSummary()
+	position, investment, err := p.store.OrderSummary(p.name, p.symbol)
 	if err != nil {
 		return errors.Wrap(err, util.FuncName())
 	}
 
+	p.state.mu.Lock()
 	p.state.position = position
 	p.state.investment = investment
 	p.state.updated = uint64(time.Now().Unix())
+	p.state.mu.Unlock()
 
 	if err = p.stateFlush(); err != nil {
 		return errors.Wrap(err, util.FuncName())
@@ -90,11 +235,14 @@ func (p *plan) stateInit() error {
 	return nil
 }
 
-// stateUpdate 更新，根据订单更新状态
+// stateUpdate 更新，根据订单扣除手续费后更新状态
 func (p *plan) stateUpdate(order *huobi.OpenOrder) error {
-	p.state.position += order.FieldAmount
-	p.state.investment += order.FieldCashAmount
+	position, investment := net(order)
+	p.state.mu.Lock()
+	p.state.position += position
+	p.state.investment += investment
 	p.state.updated = uint64(time.Now().Unix())
+	p.state.mu.Unlock()
 
 	if err := p.stateFlush(); err != nil {
 		return errors.Wrap(err, util.FuncName())
@@ -103,24 +251,211 @@ func (p *plan) stateUpdate(order *huobi.OpenOrder) error {
 	return nil
 }
 
-// New 新建一个定投计划
-func New(symbol string, amount float64,
-	period Period, client *huobi.Client) (Plan, error) {
+// net 返回订单扣除手续费后的持仓及投入变化，卖单的成交金额为负数
+func net(order *huobi.OpenOrder) (position, investment float64) {
+	if isSell(order) {
+		return order.FieldAmount, order.FieldCashAmount + order.FieldFees
+	}
+	return order.FieldAmount - order.FieldFees, order.FieldCashAmount
+}
+
+// isSell 是否为卖单
+func isSell(order *huobi.OpenOrder) bool {
+	switch huobi.TradeType(order.Type) {
+	case huobi.SellMarket, huobi.SellLimit:
+		return true
+	}
+	return false
+}
+
+// due 返回上次执行之后、不晚于 now 的所有计划执行时间（最多 maxCatchup 个）
+func (p *plan) due(now time.Time) ([]time.Time, error) {
+	from := p.started
+	last, err := p.store.LastExecution(p.name)
+	if err != nil {
+		return nil, errors.Wrap(err, util.FuncName())
+	}
+	if last != nil {
+		from = time.Unix(int64(last.Scheduled), 0)
+	}
+
+	var r []time.Time
+	for t := p.period.Next(from.In(now.Location())); !t.IsZero() && !t.After(now); t = p.period.Next(t) {
+		if r = append(r, t); len(r) > maxCatchup {
+			r = r[1:]
+		}
+	}
+
+	return r, nil
+}
+
+// class 返回投资错误的分类
+func class(err error) string {
+	switch e := errors.Cause(err).(type) {
+	case *huobi.APIError:
+		switch e.Code {
+		case "order-accountbalance-error", "account-transfer-balance-insufficient-error":
+			return ClassBalance
+		}
+		return ClassExchange
+	case *huobi.PlacedError:
+		return ClassStorage
+	case interface{ Timeout() bool }: // net.Error
+		return ClassNetwork
+	}
+	return ClassOther
+}
+
+// trade 买入指定金额，并记录订单、更新状态。
+// 已下单但确认或记录订单失败时，同时返回订单（至少有订单号）及错误，调用者不能重新下单
+func (p *plan) trade(amount float64) (*huobi.OpenOrder, error) {
+	investAttempts.Inc(p.name)
+
+	order, err := p.client.Trade(p.symbol, huobi.BuyLimit, amount, -1)
+	if err != nil {
+		investFailures.Inc(p.name, class(err))
+		if e, ok := errors.Cause(err).(*huobi.PlacedError); ok {
+			return &huobi.OpenOrder{ID: e.OrderID}, errors.Wrap(err, util.FuncName())
+		}
+		return nil, errors.Wrap(err, util.FuncName())
+	}
+
+	if err = p.addOrder(order); err != nil {
+		investFailures.Inc(p.name, ClassStorage)
+		return order, errors.Wrap(err, util.FuncName())
+	}
+
+	// 与订单记录一致，卖单的成交金额为负数
+	if isSell(order) {
+		order.FieldAmount = -order.FieldAmount
+		order.FieldCashAmount = -order.FieldCashAmount
+	}
+
+	// 订单已成交并记录，之后刷新报价失败不影响投资结果
+	investSuccesses.Inc(p.name)
+	p.state.mu.Lock()
+	p.state.invested = uint64(time.Now().Unix())
+	p.state.mu.Unlock()
+
+	if err = p.stateUpdate(order); err != nil {
+		log.Println(errors.Wrap(err, util.FuncName()))
+	}
 
+	return order, nil
+}
+
+// invest 为指定的计划执行时间买入，并记录执行结果
+func (p *plan) invest(scheduled []time.Time, amount float64) error {
+	order, err := p.trade(amount)
+	if err != nil {
+		// 已下单的周期不再重试，避免重复买入，订单由对账补录
+		status, id := db.ExecutionFailed, uint64(0)
+		if order != nil {
+			status, id = db.ExecutionPlaced, order.ID
+			p.notify(notify.Failed, "invest %v placed order %d but failed to record it, reconcile to backfill: %v",
+				amount, id, err)
+		} else {
+			p.notify(notify.Failed, "invest %v failed: %v", amount, err)
+		}
+		if e := p.addExecutions(scheduled, status, id, err); e != nil {
+			log.Println(e)
+		}
+		return errors.Wrap(err, util.FuncName())
+	}
+
+	var price float64
+	if order.FieldAmount != 0 {
+		price = order.FieldCashAmount / order.FieldAmount
+	}
+	p.notify(notify.Invested, "invested %v, got %v at %v", order.FieldCashAmount, order.FieldAmount, price)
+
+	status := db.ExecutionSuccess
+	if len(scheduled) > 1 {
+		status = db.ExecutionMerged
+	}
+
+	if err = p.addExecutions(scheduled, status, order.ID, nil); err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+
+	return nil
+}
+
+// makeup 按补投策略处理错过的周期
+func (p *plan) makeup(missed []time.Time) error {
+	if len(missed) == 0 {
+		return nil
+	}
+
+	log.Printf("%s: %d period(s) missed since %s, catchup policy: %s\n",
+		p.name, len(missed), missed[0].Format(time.RFC3339), p.catchup)
+	p.notify(notify.Missed, "%d period(s) missed since %s, catchup policy: %s",
+		len(missed), missed[0].Format(time.RFC3339), p.catchup)
+
+	switch p.catchup {
+	case CatchupExecute:
+		var err error
+		for _, t := range missed {
+			if e := p.invest([]time.Time{t}, p.amount); e != nil {
+				err = e
+			}
+		}
+		if err != nil {
+			return errors.Wrap(err, util.FuncName())
+		}
+	case CatchupMerge:
+		if err := p.invest(missed, p.amount*float64(len(missed))); err != nil {
+			return errors.Wrap(err, util.FuncName())
+		}
+	default:
+		// 只记录最后一期即可推进执行进度
+		if err := p.addExecutions(missed[len(missed)-1:], db.ExecutionSkipped, 0, nil); err != nil {
+			return errors.Wrap(err, util.FuncName())
+		}
+	}
+
+	return nil
+}
+
+// New 新建一个定投计划
+func New(opts *Options, client *huobi.Client, store db.Store) (Plan, error) {
 	p := &plan{
-		client: client,
-		period: period,
-		symbol: symbol,
-		amount: amount,
+		client:   client,
+		store:    store,
+		name:     opts.Name,
+		period:   opts.Period,
+		symbol:   opts.Symbol,
+		amount:   opts.Amount,
+		catchup:  opts.Catchup,
+		notifier: opts.Notifier,
+		started:  time.Now(),
+	}
+
+	if _, err := p.store.AddPlan(p.name); err != nil {
+		return nil, errors.Wrap(err, util.FuncName())
 	}
 
 	if err := p.stateInit(); err != nil {
 		return nil, errors.Wrap(err, util.FuncName())
 	}
 
+	// 从最近的订单恢复投资成功时间，重启后仍能发现错过的周期
+	orders, err := p.store.Orders(&db.Filter{Plan: p.name, Symbol: p.symbol, Limit: 1})
+	if err != nil {
+		return nil, errors.Wrap(err, util.FuncName())
+	}
+	if len(orders) > 0 {
+		p.state.invested = orders[0].Created
+	}
+
 	return p, nil
 }
 
+// Name 获取计划名称
+func (p *plan) Name() string {
+	return p.name
+}
+
 // Period 获取定投周期
 func (p *plan) Period() Period {
 	return p.period
@@ -128,33 +463,87 @@ func (p *plan) Period() Period {
 
 // Invest 执行一次投资
 func (p *plan) Invest() error {
-	order, err := p.client.Trade(p.symbol, huobi.BuyLimit, p.amount, -1)
+	if _, err := p.trade(p.amount); err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+
+	return nil
+}
+
+// Execute 执行到期的定投，最近一期正常投资，更早错过的周期按补投策略处理
+func (p *plan) Execute(now time.Time) error {
+	due, err := p.due(now)
 	if err != nil {
 		return errors.Wrap(err, util.FuncName())
 	}
+	if len(due) == 0 {
+		return nil // 本期已执行过
+	}
 
-	switch huobi.TradeType(order.Type) {
-	case huobi.SellMarket, huobi.SellLimit:
-		order.FieldAmount = -order.FieldAmount
-		order.FieldCashAmount = -order.FieldCashAmount
+	if err = p.makeup(due[:len(due)-1]); err != nil {
+		log.Println(err)
 	}
 
-	if err = p.addOrder(order); err != nil {
+	if err = p.invest(due[len(due)-1:], p.amount); err != nil {
 		return errors.Wrap(err, util.FuncName())
 	}
 
-	if err = p.stateUpdate(order); err != nil {
+	return nil
+}
+
+// Catchup 按补投策略处理停机期间错过的周期
+func (p *plan) Catchup(now time.Time) error {
+	missed, err := p.due(now)
+	if err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+
+	if err = p.makeup(missed); err != nil {
 		return errors.Wrap(err, util.FuncName())
 	}
 
 	return nil
 }
 
+// Skip 跳过到期的定投，只记录最后一期以推进执行进度
+func (p *plan) Skip(now time.Time) error {
+	due, err := p.due(now)
+	if err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+	if len(due) == 0 {
+		return nil
+	}
+
+	if err = p.addExecutions(due[len(due)-1:], db.ExecutionSkipped, 0, nil); err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+
+	return nil
+}
+
+// State 获取最近一次更新的持仓及净值
+func (p *plan) State() State {
+	p.state.mu.Lock()
+	defer p.state.mu.Unlock()
+
+	return State{
+		Position:   p.state.position,
+		Investment: p.state.investment,
+		Price:      p.state.price,
+		Equity:     p.state.equity,
+		Updated:    p.state.updated,
+		Invested:   p.state.invested,
+		Monitored:  p.state.monitored,
+	}
+}
+
 // Monitor 执行一次监控
 func (p *plan) Monitor() error {
 	var err error
 
-	if err = p.stateFlush(); err != nil {
+	// 重新汇总订单，包含导入及对账补录的订单
+	if err = p.stateInit(); err != nil {
 		return errors.Wrap(err, util.FuncName())
 	}
 
@@ -162,6 +551,10 @@ func (p *plan) Monitor() error {
 		return errors.Wrap(err, util.FuncName())
 	}
 
+	p.state.mu.Lock()
+	p.state.monitored = uint64(time.Now().Unix())
+	p.state.mu.Unlock()
+
 	// TODO 赎回检查
 
 	return nil
@@ -1 +1,115 @@
 package plan
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/modood/aip/db"
+	"github.com/modood/aip/huobi"
+
+	"github.com/pkg/errors"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCatchup(t *testing.T) {
+	Convey("should skip missed periods and remember the latest one", t, func() {
+		store, err := db.Init(db.DriverSQLite, "/tmp/aip.sqlite3")
+		So(err, ShouldBeNil)
+		defer store.Close()
+
+		period, err := NewDaily(0, 0, 0)
+		So(err, ShouldBeNil)
+
+		p := &plan{
+			store:   store,
+			name:    "catchup" + time.Now().Format("150405.000"),
+			period:  period,
+			symbol:  "btcusdt",
+			catchup: CatchupSkip,
+		}
+
+		now := time.Date(2018, time.September, 10, 12, 0, 0, 0, time.UTC)
+		So(store.AddExecution(&db.Execution{
+			Plan:      p.name,
+			Symbol:    p.symbol,
+			Scheduled: uint64(now.AddDate(0, 0, -3).Unix()),
+			Status:    db.ExecutionSuccess,
+		}), ShouldBeNil)
+
+		due, err := p.due(now)
+		So(err, ShouldBeNil)
+		So(len(due), ShouldEqual, 3)
+
+		So(p.Catchup(now), ShouldBeNil)
+
+		due, err = p.due(now)
+		So(err, ShouldBeNil)
+		So(due, ShouldBeEmpty)
+	})
+
+	Convey("should not retry a period whose order was placed but not recorded", t, func() {
+		store, err := db.Init(db.DriverSQLite, "/tmp/aip.sqlite3")
+		So(err, ShouldBeNil)
+		defer store.Close()
+
+		period, err := NewDaily(0, 0, 0)
+		So(err, ShouldBeNil)
+
+		p := &plan{
+			store:   store,
+			name:    "placed" + time.Now().Format("150405.000"),
+			period:  period,
+			symbol:  "btcusdt",
+			catchup: CatchupExecute,
+		}
+
+		now := time.Date(2018, time.September, 10, 12, 0, 0, 0, time.UTC)
+		for i, status := range []string{db.ExecutionSuccess, db.ExecutionPlaced, db.ExecutionFailed} {
+			So(store.AddExecution(&db.Execution{
+				Plan:      p.name,
+				Symbol:    p.symbol,
+				Scheduled: uint64(now.AddDate(0, 0, i-2).Truncate(24 * time.Hour).Unix()),
+				Status:    status,
+			}), ShouldBeNil)
+		}
+
+		// 只有下单前失败的周期需要补投
+		due, err := p.due(now)
+		So(err, ShouldBeNil)
+		So(len(due), ShouldEqual, 1)
+		So(due[0], ShouldEqual, time.Date(2018, time.September, 10, 0, 0, 0, 0, time.UTC))
+	})
+}
+
+func TestNet(t *testing.T) {
+	Convey("should deduct fees in base currency for buy orders", t, func() {
+		position, investment := net(&huobi.OpenOrder{Type: "buy-market",
+			FieldAmount: 0.01, FieldCashAmount: 64, FieldFees: 0.00002})
+		So(position, ShouldAlmostEqual, 0.00998, 1e-12)
+		So(investment, ShouldEqual, 64)
+	})
+
+	Convey("should deduct fees in quote currency for sell orders", t, func() {
+		position, investment := net(&huobi.OpenOrder{Type: "sell-limit",
+			FieldAmount: -0.005, FieldCashAmount: -35, FieldFees: 0.07})
+		So(position, ShouldEqual, -0.005)
+		So(investment, ShouldAlmostEqual, -34.93, 1e-9)
+	})
+}
+
+func TestClass(t *testing.T) {
+	Convey("should classify invest errors", t, func() {
+		api := func(code string) error {
+			return errors.Wrap(&huobi.APIError{Code: code}, "huobi.handle")
+		}
+		So(class(api("order-accountbalance-error")), ShouldEqual, ClassBalance)
+		So(class(api("gateway-internal-error")), ShouldEqual, ClassExchange)
+		So(class(errors.Wrap(&url.Error{Op: "Post", URL: "https://api.huobi.pro",
+			Err: context.DeadlineExceeded}, "huobi.req")), ShouldEqual, ClassNetwork)
+		So(class(errors.Wrap(&huobi.PlacedError{OrderID: 1, Err: errors.New("timeout")}, "huobi.Trade")),
+			ShouldEqual, ClassStorage)
+		So(class(errors.New("unknown")), ShouldEqual, ClassOther)
+	})
+}
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/modood/aip/config"
+	"github.com/modood/aip/db"
+	"github.com/modood/aip/huobi"
+	"github.com/modood/aip/plan"
+	"github.com/modood/aip/util"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// reconcileGrace 对账忽略最近下单的订单，这些订单可能正由计划记录
+const reconcileGrace = 5 * time.Minute
+
+var reconcileCmd = &cobra.Command{
+	Use:   "reconcile",
+	Short: "compare the database with the exchange balances and recent orders",
+	Long: `Compare the positions computed from the database with the spot account balances,
+and the recorded orders with the exchange orders of the last 2 days. Discrepancies
+are printed and recorded in the database; open discrepancies that are no longer
+found are marked resolved.`,
+	Args: cobra.NoArgs,
+	RunE: reconcile,
+}
+
+func init() {
+	reconcileCmd.Flags().Bool("backfill", false, "record missing orders and update stale order states from the exchange")
+	reconcileCmd.Flags().Bool("list", false, "only list the recorded discrepancies that are still open")
+}
+
+func reconcile(cmd *cobra.Command, args []string) error {
+	flags := cmd.Flags()
+	backfill, err := flags.GetBool("backfill")
+	if err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+	list, err := flags.GetBool("list")
+	if err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+
+	e, err := setupTrade()
+	if err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+
+	var found []*db.Reconciliation
+	if list {
+		found, err = e.store.Reconciliations(db.ReconcileOpen)
+	} else {
+		rc := &reconciler{client: e.client, store: e.store, plans: e.cfg.Plans, backfill: backfill}
+		found, err = rc.run(time.Now().In(e.loc))
+	}
+	if err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+
+	if len(found) == 0 {
+		fmt.Println("no discrepancies found")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KIND\tPLAN\tSYMBOL\tCURRENCY\tORDER\tEXPECTED\tACTUAL\tSTATUS\tDETAIL")
+	for _, d := range found {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%v\t%v\t%s\t%s\n",
+			d.Kind, d.Plan, d.Symbol, d.Currency, d.OrderID, d.Expected, d.Actual, d.Status, d.Detail)
+	}
+	if err = w.Flush(); err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+
+	return nil
+}
+
+// reconciler 对账：比较数据库计算的持仓与现货账户余额，以及数据库中的订单与交易所最近的订单
+type reconciler struct {
+	client   *huobi.Client
+	store    db.Store
+	plans    []*config.Plan
+	backfill bool // 按交易所数据补录缺失的订单并更新状态过期的订单
+
+	checked map[subject]bool // 本次对账检查过的订单及币种余额
+}
+
+// subject 对账的对象，订单以编号区分，余额以币种区分
+type subject struct {
+	orderID  uint64
+	currency string
+}
+
+// subjectOf 返回差异的对账对象
+func subjectOf(d *db.Reconciliation) subject {
+	if d.OrderID != 0 {
+		return subject{orderID: d.OrderID}
+	}
+	return subject{currency: d.Currency}
+}
+
+// run 对账并记录发现的差异，之前发现、本次检查过但不再发现的差异标记为已解决，一并返回
+func (rc *reconciler) run(now time.Time) ([]*db.Reconciliation, error) {
+	rc.checked = make(map[subject]bool)
+
+	// 交易品种对应的计划，多个计划交易同一品种时无法确定缺失订单的归属
+	owners := make(map[string][]string)
+	for _, c := range rc.plans {
+		owners[c.Symbol] = append(owners[c.Symbol], c.Name)
+	}
+
+	var symbols []string
+	for symbol := range owners {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+
+	var r []*db.Reconciliation
+	for _, symbol := range symbols {
+		found, err := rc.orders(symbol, owners[symbol], now)
+		if err != nil {
+			return nil, errors.Wrap(err, util.FuncName())
+		}
+		r = append(r, found...)
+	}
+
+	found, err := rc.balances(symbols, owners)
+	if err != nil {
+		return nil, errors.Wrap(err, util.FuncName())
+	}
+	r = append(r, found...)
+
+	for _, d := range r {
+		if err = rc.store.SaveReconciliation(d); err != nil {
+			return nil, errors.Wrap(err, util.FuncName())
+		}
+	}
+
+	resolved, err := rc.resolve(r)
+	if err != nil {
+		return nil, errors.Wrap(err, util.FuncName())
+	}
+
+	return append(r, resolved...), nil
+}
+
+// resolve 将本次检查过但不再发现的待处理差异标记为已解决，超出对账范围的订单保持原状
+func (rc *reconciler) resolve(found []*db.Reconciliation) ([]*db.Reconciliation, error) {
+	type key struct {
+		kind string
+		subject
+	}
+	seen := make(map[key]bool)
+	for _, d := range found {
+		seen[key{d.Kind, subjectOf(d)}] = true
+	}
+
+	open, err := rc.store.Reconciliations(db.ReconcileOpen)
+	if err != nil {
+		return nil, errors.Wrap(err, util.FuncName())
+	}
+
+	var r []*db.Reconciliation
+	for _, d := range open {
+		s := subjectOf(d)
+		if seen[key{d.Kind, s}] || !rc.checked[s] {
+			continue
+		}
+
+		d.Status = db.ReconcileResolved
+		if err = rc.store.SaveReconciliation(d); err != nil {
+			return nil, errors.Wrap(err, util.FuncName())
+		}
+		r = append(r, d)
+	}
+
+	return r, nil
+}
+
+// orders 比较交易品种最近的订单
+func (rc *reconciler) orders(symbol string, names []string, now time.Time) ([]*db.Reconciliation, error) {
+	s, err := rc.client.Symbol(symbol)
+	if err != nil {
+		return nil, errors.Wrap(err, util.FuncName())
+	}
+
+	var name string
+	if len(names) == 1 {
+		name = names[0]
+	}
+
+	start := now.AddDate(0, 0, 1-importWindow)
+	orders, err := orderHistory(rc.client, &huobi.OrderQuery{
+		Symbol: symbol,
+		States: "submitted,partial-filled,partial-canceled,filled",
+		Start:  start,
+		End:    now,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, util.FuncName())
+	}
+
+	// 火币按自己的时区划分日期，数据库多查一天
+	recorded, err := rc.store.Orders(&db.Filter{Symbol: symbol, Since: uint64(start.AddDate(0, 0, -1).Unix())})
+	if err != nil {
+		return nil, errors.Wrap(err, util.FuncName())
+	}
+	byID := make(map[uint64]*db.Order)
+	for _, o := range recorded {
+		byID[o.ID] = o
+	}
+
+	var (
+		r    []*db.Reconciliation
+		seen = make(map[uint64]bool)
+	)
+	for _, o := range orders {
+		seen[o.ID] = true
+		if time.Unix(0, int64(o.CreatedAt)*int64(time.Millisecond)).After(now.Add(-reconcileGrace)) {
+			continue
+		}
+
+		rc.checked[subject{orderID: o.ID}] = true
+		d, err := rc.check(name, s, o, byID[o.ID])
+		if err != nil {
+			return nil, errors.Wrap(err, util.FuncName())
+		}
+		if d != nil {
+			r = append(r, d)
+		}
+	}
+
+	// 数据库中未完成、交易所最近订单中没有的订单，如已撤销，需逐个查询
+	for _, rec := range recorded {
+		if seen[rec.ID] || !pending(rec.State) {
+			continue
+		}
+
+		o, err := rc.client.OpenOrder(rec.ID)
+		if err != nil {
+			return nil, errors.Wrap(err, util.FuncName())
+		}
+
+		rc.checked[subject{orderID: o.ID}] = true
+		d, err := rc.check(rec.Plan, s, o, rec)
+		if err != nil {
+			return nil, errors.Wrap(err, util.FuncName())
+		}
+		if d != nil {
+			r = append(r, d)
+		}
+	}
+
+	return r, nil
+}
+
+// check 比较交易所订单与数据库中的记录，没有差异时返回 nil。
+// 数据库中卖单的成交金额为负数，按绝对值比较，差额小于下单精度时忽略
+// 参数 name 交易该品种的计划，无法确定时为空
+// 参数 rec  数据库中的记录，没有记录时为 nil
+func (rc *reconciler) check(name string, s *huobi.Symbol, o *huobi.OpenOrder, rec *db.Order) (*db.Reconciliation, error) {
+	d := &db.Reconciliation{
+		Plan:     name,
+		Symbol:   o.Symbol,
+		Currency: s.BaseCurrency,
+		OrderID:  o.ID,
+		Actual:   o.FieldAmount,
+		Status:   db.ReconcileOpen,
+	}
+
+	switch {
+	case rec == nil && o.Source != "api":
+		d.Kind = db.ReconcileManual
+		d.Detail = fmt.Sprintf("%s %s order placed from %s", o.State, o.Type, o.Source)
+	case rec == nil:
+		d.Kind = db.ReconcileMissing
+		d.Detail = fmt.Sprintf("%s %s order is not recorded", o.State, o.Type)
+	case rec.State != o.State || math.Abs(math.Abs(rec.BaseAmount)-o.FieldAmount) >= math.Pow10(-s.AmountPrecision):
+		d.Kind, d.Plan, d.Expected = db.ReconcileState, rec.Plan, math.Abs(rec.BaseAmount)
+		d.Detail = fmt.Sprintf("recorded as %q, %s on the exchange", rec.State, o.State)
+	default:
+		return nil, nil
+	}
+
+	// 手动交易不属于任何计划，未完成的订单等成交后再补录
+	if !rc.backfill || d.Kind == db.ReconcileManual || d.Plan == "" || pending(o.State) {
+		return d, nil
+	}
+
+	if _, err := rc.store.SaveOrder(plan.Record(d.Plan, s, o)); err != nil {
+		return nil, errors.Wrap(err, util.FuncName())
+	}
+	d.Status = db.ReconcileBackfilled
+
+	return d, nil
+}
+
+// balances 比较数据库计算的各基础货币持仓与现货账户余额，差额小于下单精度时忽略
+func (rc *reconciler) balances(symbols []string, owners map[string][]string) ([]*db.Reconciliation, error) {
+	var (
+		currencies []string
+		expected   = make(map[string]float64)
+		precision  = make(map[string]int)
+	)
+
+	for _, symbol := range symbols {
+		s, err := rc.client.Symbol(symbol)
+		if err != nil {
+			return nil, errors.Wrap(err, util.FuncName())
+		}
+
+		if _, ok := expected[s.BaseCurrency]; !ok {
+			currencies = append(currencies, s.BaseCurrency)
+		}
+		for _, name := range owners[symbol] {
+			position, _, err := rc.store.OrderSummary(name, symbol)
+			if err != nil {
+				return nil, errors.Wrap(err, util.FuncName())
+			}
+			expected[s.BaseCurrency] += position
+		}
+		if s.AmountPrecision > precision[s.BaseCurrency] {
+			precision[s.BaseCurrency] = s.AmountPrecision
+		}
+	}
+
+	var r []*db.Reconciliation
+	for _, currency := range currencies {
+		actual, err := rc.client.SpotAccountBalance(currency)
+		if err != nil {
+			return nil, errors.Wrap(err, util.FuncName())
+		}
+		rc.checked[subject{currency: currency}] = true
+
+		diff, prec := actual-expected[currency], precision[currency]
+		if math.Abs(diff) < math.Pow10(-prec) {
+			continue
+		}
+
+		detail := fmt.Sprintf("balance exceeds the recorded position by %.*f", prec, diff)
+		if diff < 0 {
+			detail = fmt.Sprintf("recorded position exceeds the balance by %.*f", prec, -diff)
+		}
+		r = append(r, &db.Reconciliation{
+			Kind:     db.ReconcileBalance,
+			Currency: currency,
+			Expected: expected[currency],
+			Actual:   actual,
+			Status:   db.ReconcileOpen,
+			Detail:   detail,
+		})
+	}
+
+	return r, nil
+}
+
+// describe 返回对账差异的说明，用于日志
+func describe(d *db.Reconciliation) string {
+	if d.OrderID == 0 {
+		return fmt.Sprintf("%s of %s: %s (%s)", d.Kind, d.Currency, d.Detail, d.Status)
+	}
+	return fmt.Sprintf("%s order %d of %s: %s (%s)", d.Kind, d.OrderID, d.Symbol, d.Detail, d.Status)
+}
+
+// pending 订单是否尚未完成
+func pending(state string) bool {
+	switch state {
+	case "pre-submitted", "submitted", "partial-filled":
+		return true
+	}
+	return false
+}
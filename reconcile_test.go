@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/modood/aip/config"
+	"github.com/modood/aip/db"
+	"github.com/modood/aip/huobi"
+	"github.com/modood/aip/plan"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestReconcile(t *testing.T) {
+	Convey("should report and backfill discrepancies", t, func() {
+		now := time.Date(2018, 9, 10, 12, 0, 0, 0, time.UTC)
+		order := func(id uint64, source, state string, ago time.Duration) *huobi.OpenOrder {
+			return &huobi.OpenOrder{ID: id, Symbol: "btcusdt", Type: "buy-market", State: state,
+				Source: source, Amount: 10, FieldAmount: 0.001, FieldCashAmount: 10,
+				CreatedAt: uint64(now.Add(-ago).Unix() * 1000)}
+		}
+
+		orders := []*huobi.OpenOrder{
+			order(1, "api", "filled", time.Hour),       // 已正确记录
+			order(2, "api", "filled", time.Hour),       // 未记录
+			order(3, "web", "filled", time.Hour),       // 手动交易
+			order(4, "api", "filled", time.Hour),       // 记录为未完成
+			order(5, "api", "filled", time.Minute),     // 刚下单，可能正在记录
+			order(6, "api", "canceled", 2*time.Hour),   // 记录为未完成，已撤销
+			order(7, "api", "submitted", 3*time.Hour),  // 未记录且未完成
+			order(8, "api", "filled", 30*24*time.Hour), // 超出对账范围
+			order(9, "api", "filled", time.Hour),       // 卖单，已正确记录
+			order(10, "api", "filled", time.Hour),      // 卖单，未记录
+		}
+		orders[5].FieldAmount = 0
+		for _, o := range orders[8:] {
+			o.Type, o.FieldCashAmount = "sell-market", 7
+		}
+
+		server := exchange(orders, 1)
+		defer server.Close()
+
+		client, err := huobi.NewClient(server.URL, "apikey", "apisecret")
+		So(err, ShouldBeNil)
+
+		path := filepath.Join(os.TempDir(), fmt.Sprintf("aip-reconcile-%d.sqlite3", time.Now().UnixNano()))
+		defer os.Remove(path)
+		store, err := db.Init(db.DriverSQLite, path)
+		So(err, ShouldBeNil)
+		defer store.Close()
+
+		s, err := client.Symbol("btcusdt")
+		So(err, ShouldBeNil)
+		_, err = store.AddPlan("default")
+		So(err, ShouldBeNil)
+
+		So(store.AddOrder(plan.Record("default", s, orders[0])), ShouldBeNil)
+		stale := plan.Record("default", s, orders[3])
+		stale.State, stale.BaseAmount = "submitted", 0
+		So(store.AddOrder(stale), ShouldBeNil)
+		canceled := plan.Record("default", s, orders[5])
+		canceled.State = "submitted"
+		So(store.AddOrder(canceled), ShouldBeNil)
+		sell := plan.Record("default", s, orders[8])
+		sell.BaseAmount -= 1e-12 // 浮点误差不视为差异
+		So(store.AddOrder(sell), ShouldBeNil)
+
+		rc := &reconciler{client: client, store: store, backfill: true,
+			plans: []*config.Plan{{Name: "default", Symbol: "btcusdt"}}}
+
+		found, err := rc.run(now)
+		So(err, ShouldBeNil)
+
+		kinds := make(map[uint64]string)
+		statuses := make(map[uint64]string)
+		for _, d := range found {
+			kinds[d.OrderID], statuses[d.OrderID] = d.Kind, d.Status
+		}
+		So(kinds, ShouldResemble, map[uint64]string{
+			0:  db.ReconcileBalance,
+			2:  db.ReconcileMissing,
+			3:  db.ReconcileManual,
+			4:  db.ReconcileState,
+			6:  db.ReconcileState,
+			7:  db.ReconcileMissing,
+			10: db.ReconcileMissing,
+		})
+		So(statuses, ShouldResemble, map[uint64]string{
+			0:  db.ReconcileOpen,
+			2:  db.ReconcileBackfilled,
+			3:  db.ReconcileOpen,
+			4:  db.ReconcileBackfilled,
+			6:  db.ReconcileBackfilled,
+			7:  db.ReconcileOpen,
+			10: db.ReconcileBackfilled,
+		})
+
+		recorded, err := store.Orders(&db.Filter{Plan: "default"})
+		So(err, ShouldBeNil)
+		So(len(recorded), ShouldEqual, 6)
+		for _, o := range recorded {
+			So(o.State, ShouldEqual, orders[o.ID-1].State)
+			if o.ID >= 9 {
+				So(o.BaseAmount, ShouldBeLessThan, 0) // 补录的卖单与计划记录的一致
+				So(o.QuoteAmount, ShouldEqual, -7)
+			}
+		}
+
+		open, err := store.Reconciliations(db.ReconcileOpen)
+		So(err, ShouldBeNil)
+		So(len(open), ShouldEqual, 3)
+
+		// 补录后再次对账只剩下无法自动处理的差异
+		found, err = rc.run(now)
+		So(err, ShouldBeNil)
+		So(len(found), ShouldEqual, 3)
+
+		// 订单记录后，之前的差异标记为已解决
+		So(store.AddOrder(plan.Record("default", s, orders[6])), ShouldBeNil)
+		found, err = rc.run(now)
+		So(err, ShouldBeNil)
+		statuses = make(map[uint64]string)
+		for _, d := range found {
+			statuses[d.OrderID] = d.Status
+		}
+		So(statuses, ShouldResemble, map[uint64]string{
+			0: db.ReconcileOpen,
+			3: db.ReconcileOpen,
+			7: db.ReconcileResolved,
+		})
+
+		open, err = store.Reconciliations(db.ReconcileOpen)
+		So(err, ShouldBeNil)
+		So(len(open), ShouldEqual, 2)
+	})
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/modood/aip/analytics"
+	"github.com/modood/aip/config"
+	"github.com/modood/aip/db"
+	"github.com/modood/aip/huobi"
+	"github.com/modood/aip/util"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var errUnknownRange = errors.New("unknown range, available: all, ytd, or a number followed by d, w, m or y such as 30d")
+
+var reportCmd = &cobra.Command{
+	Use:   "report",
+	Short: "print returns, drawdown and volatility of the plans over time ranges, compared with a lump sum",
+	Long: `Print the performance of the plans over time ranges: the amount contributed, the
+equity and gain at the end of the range, simple ROI, money-weighted return (XIRR,
+annualized), time-weighted return, max drawdown, annualized volatility and the average
+cost of the position. Positions are rebuilt from the recorded orders and valued with the
+recorded statistics, the current price for ranges ending now.
+
+To tell whether DCA helped, the plan is compared with investing the same total at once
+at the start of the range (the first investment for "all"), bought at the opening price
+of that day from the exchange's daily klines and held, and with holding the quote
+currency instead.
+
+With --html the report is written as a self-contained HTML page, charts included, for
+the first range: equity and investment, price with the buys and the average cost, and
+the drawdown.`,
+	Args: cobra.NoArgs,
+	RunE: report,
+}
+
+func init() {
+	reportCmd.Flags().String("plan", "", "only report the plan with this name")
+	reportCmd.Flags().StringSlice("range", []string{"all"}, "ranges to report: all, ytd, or a number followed by d, w, m or y such as 30d")
+	reportCmd.Flags().String("since", "", "report a single range starting on this date instead, yyyy-mm-dd")
+	reportCmd.Flags().String("until", "", "report a single range ending before this date instead, yyyy-mm-dd")
+	reportCmd.Flags().String("html", "", "write the report with charts as a self-contained html page to this file instead")
+}
+
+// period 报告的时间范围，since 为零值时从第一笔订单开始，until 为零值时到当前时间
+type period struct {
+	label string
+	since time.Time
+	until time.Time
+}
+
+// result 计划在一个时间范围内的收益指标及对比基准
+type result struct {
+	period    period
+	points    []analytics.Point
+	summary   *analytics.Summary
+	benchmark *analytics.Benchmark
+}
+
+// planReport 计划的报告，没有订单时 results 为空
+type planReport struct {
+	plan    *config.Plan
+	symbol  *huobi.Symbol
+	orders  []*db.Order // 全部订单，按时间倒序
+	results []*result
+}
+
+func report(cmd *cobra.Command, args []string) error {
+	flags := cmd.Flags()
+	name, err := flags.GetString("plan")
+	if err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+	ranges, err := flags.GetStringSlice("range")
+	if err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+	html, err := flags.GetString("html")
+	if err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+
+	e, err := setup()
+	if err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+
+	now := time.Now().In(e.loc)
+	periods, err := periodFlags(cmd, ranges, e.loc, now)
+	if err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+
+	plans, err := e.plans(name)
+	if err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+
+	reports := make([]*planReport, 0, len(plans))
+	for _, c := range plans {
+		r, err := e.report(c, periods, now)
+		if err != nil {
+			return errors.Wrap(err, c.Name)
+		}
+		reports = append(reports, r)
+	}
+
+	if html != "" {
+		w, closer, err := create(html)
+		if err != nil {
+			return errors.Wrap(err, util.FuncName())
+		}
+		defer closer()
+
+		if err = writeHTML(w, reports, e.loc, now); err != nil {
+			return errors.Wrap(err, util.FuncName())
+		}
+		return nil
+	}
+
+	if err = printReports(os.Stdout, reports, e.loc); err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+
+	return nil
+}
+
+// periodFlags 返回 --since 及 --until 指定的时间范围，未指定时返回 --range 的各个时间范围
+func periodFlags(cmd *cobra.Command, ranges []string, loc *time.Location, now time.Time) ([]period, error) {
+	since, err := dateFlag(cmd, "since", loc)
+	if err != nil {
+		return nil, err
+	}
+	until, err := dateFlag(cmd, "until", loc)
+	if err != nil {
+		return nil, err
+	}
+
+	if since > 0 || until > 0 {
+		p := period{label: "custom"}
+		if since > 0 {
+			p.since = time.Unix(int64(since), 0).In(loc)
+		}
+		if until > 0 {
+			p.until = time.Unix(int64(until), 0).In(loc)
+		}
+		return []period{p}, nil
+	}
+
+	periods := make([]period, 0, len(ranges))
+	for _, s := range ranges {
+		t, err := parseRange(s, now)
+		if err != nil {
+			return nil, err
+		}
+		periods = append(periods, period{label: s, since: t})
+	}
+	return periods, nil
+}
+
+// parseRange 解析截至 now 的时间范围并返回开始时间：all 为全部（零值），ytd 为今年以来，
+// 数字加 d、w、m、y 为最近若干天、周、月、年
+func parseRange(s string, now time.Time) (time.Time, error) {
+	switch s {
+	case "all":
+		return time.Time{}, nil
+	case "ytd":
+		return time.Date(now.Year(), 1, 1, 0, 0, 0, 0, now.Location()), nil
+	}
+
+	if len(s) < 2 {
+		return time.Time{}, errors.Wrap(errUnknownRange, s)
+	}
+	n, err := strconv.Atoi(s[:len(s)-1])
+	if err != nil || n <= 0 {
+		return time.Time{}, errors.Wrap(errUnknownRange, s)
+	}
+
+	switch s[len(s)-1] {
+	case 'd':
+		return now.AddDate(0, 0, -n), nil
+	case 'w':
+		return now.AddDate(0, 0, -7*n), nil
+	case 'm':
+		return now.AddDate(0, -n, 0), nil
+	case 'y':
+		return now.AddDate(-n, 0, 0), nil
+	}
+	return time.Time{}, errors.Wrap(errUnknownRange, s)
+}
+
+// report 按订单重建计划在各时间范围内的持仓，以统计的价格估值并计算收益指标
+func (e *env) report(c *config.Plan, periods []period, now time.Time) (*planReport, error) {
+	sym, err := e.client.Symbol(c.Symbol)
+	if err != nil {
+		return nil, errors.Wrap(err, util.FuncName())
+	}
+	r := &planReport{plan: c, symbol: sym}
+
+	// 按时间倒序返回，最后一笔为第一笔订单
+	orders, err := e.store.Orders(&db.Filter{Plan: c.Name, Symbol: c.Symbol})
+	if err != nil {
+		return nil, errors.Wrap(err, util.FuncName())
+	}
+	if len(orders) == 0 {
+		return r, nil
+	}
+	r.orders = orders
+	first := time.Unix(int64(orders[len(orders)-1].Created), 0).In(e.loc)
+
+	// 日 K 线覆盖最早的时间范围，用于一次性买入的价格
+	earliest := now
+	for _, p := range periods {
+		if p.since.Before(earliest) {
+			earliest = p.since
+		}
+	}
+	if earliest.Before(first) {
+		earliest = first
+	}
+	days := int(now.Sub(earliest)/(24*time.Hour)) + 2
+	if days > huobi.KlineLimit {
+		days = huobi.KlineLimit
+	}
+	klines, err := e.client.Klines(c.Symbol, "1day", days)
+	if err != nil {
+		return nil, errors.Wrap(err, util.FuncName())
+	}
+
+	price := e.priceAt(c.Symbol, now)
+	for _, p := range periods {
+		since, until := p.since, p.until
+		if since.Before(first) {
+			since = first
+		}
+		if until.IsZero() || until.After(now) {
+			until = now
+		}
+		if !since.Before(until) {
+			r.results = append(r.results, &result{period: p, summary: &analytics.Summary{},
+				benchmark: &analytics.Benchmark{}})
+			continue
+		}
+
+		start, err := price(since)
+		if err != nil {
+			return nil, errors.Wrap(err, util.FuncName())
+		}
+		prices := []analytics.Price{{Time: since, Price: start}}
+
+		stats, err := e.store.ListStatistics(&db.Filter{Plan: c.Name, Symbol: c.Symbol,
+			Since: uint64(since.Unix()), Until: uint64(until.Unix())})
+		if err != nil {
+			return nil, errors.Wrap(err, util.FuncName())
+		}
+		for i := len(stats) - 1; i >= 0; i-- {
+			prices = append(prices, analytics.Price{Time: time.Unix(int64(stats[i].Created), 0), Price: stats[i].Price})
+		}
+
+		end, err := price(until)
+		if err != nil {
+			return nil, errors.Wrap(err, util.FuncName())
+		}
+		prices = append(prices, analytics.Price{Time: until, Price: end})
+
+		open, ok := openAt(klines, since)
+		if !ok {
+			open = start
+		}
+
+		points, flows := analytics.Build(orders, sym.BaseCurrency, prices)
+		r.results = append(r.results, &result{
+			period:    p,
+			points:    points,
+			summary:   analytics.Analyze(points, flows),
+			benchmark: analytics.Compare(points, flows, open, end),
+		})
+	}
+
+	return r, nil
+}
+
+// openAt 返回包含 at 的日 K 线的开盘价，K 线按时间倒序；不在 K 线范围内时返回 false
+func openAt(klines []*huobi.Kline, at time.Time) (float64, bool) {
+	t := uint64(at.Unix())
+	for _, k := range klines {
+		if k.ID <= t {
+			return k.Open, t < k.ID+uint64(24*time.Hour/time.Second)
+		}
+	}
+	return 0, false
+}
+
+// printReports 以表格输出各计划的报告
+func printReports(out io.Writer, reports []*planReport, loc *time.Location) error {
+	for _, r := range reports {
+		fmt.Fprintf(out, "plan:   %s\n", r.plan.Name)
+		fmt.Fprintf(out, "symbol: %s\n", r.plan.Symbol)
+		if len(r.results) == 0 {
+			fmt.Fprintf(out, "no orders\n\n")
+			continue
+		}
+		fmt.Fprintln(out)
+
+		for _, table := range r.tables(loc) {
+			w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+			for _, row := range table {
+				fmt.Fprintln(w, strings.Join(row, "\t"))
+			}
+			if err := w.Flush(); err != nil {
+				return errors.Wrap(err, util.FuncName())
+			}
+			fmt.Fprintln(out)
+		}
+	}
+
+	return nil
+}
+
+// tables 返回收益指标及对比基准两个表格，第一行为表头，金额按交易品种的价格精度格式化
+func (r *planReport) tables(loc *time.Location) [][][]string {
+	var (
+		prec       = r.symbol.PricePrecision
+		summaries  = [][]string{{"RANGE", "FROM", "TO", "CONTRIBUTED", "EQUITY", "GAIN", "ROI", "XIRR", "TWR", "MAX DRAWDOWN", "VOLATILITY", "AVERAGE COST"}}
+		benchmarks = [][]string{{"RANGE", "INVESTED", "DCA", "LUMP SUM", "HOLD " + strings.ToUpper(r.symbol.QuoteCurrency), "DCA VS LUMP SUM"}}
+		amount     = func(v float64) string { return strconv.FormatFloat(v, 'f', prec, 64) }
+	)
+
+	for _, res := range r.results {
+		s, b := res.summary, res.benchmark
+		if s.Start.IsZero() {
+			summaries = append(summaries, []string{res.period.label, "-", "-", "-", "-", "-", "-", "-", "-", "-", "-", "-"})
+			benchmarks = append(benchmarks, []string{res.period.label, "-", "-", "-", "-", "-"})
+			continue
+		}
+
+		cost := "-"
+		if s.AverageCost > 0 {
+			cost = amount(s.AverageCost)
+		}
+		gain := amount(s.Gain)
+		if s.Gain >= 0 {
+			gain = "+" + gain
+		}
+		summaries = append(summaries, []string{
+			res.period.label,
+			s.Start.In(loc).Format(dateLayout),
+			s.End.In(loc).Format(dateLayout),
+			amount(s.Contributed),
+			amount(s.Equity),
+			gain,
+			percent(s.ROI, true),
+			percent(s.XIRR, true),
+			percent(s.TWR, true),
+			percent(s.MaxDrawdown, true),
+			percent(s.Volatility, false),
+			cost,
+		})
+		benchmarks = append(benchmarks, []string{
+			res.period.label,
+			amount(b.Invested),
+			amount(b.DCA),
+			amount(b.LumpSum),
+			amount(b.Quote),
+			percent(b.Advantage, true),
+		})
+	}
+
+	return [][][]string{summaries, benchmarks}
+}
+
+// percent 将小数格式化为百分比，sign 为 true 时总是带符号
+func percent(v float64, sign bool) string {
+	if sign {
+		return fmt.Sprintf("%+.2f%%", v*100)
+	}
+	return fmt.Sprintf("%.2f%%", v*100)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/modood/aip/analytics"
+	"github.com/modood/aip/config"
+	"github.com/modood/aip/db"
+	"github.com/modood/aip/huobi"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestReport(t *testing.T) {
+	now := time.Date(2018, 9, 15, 10, 0, 0, 0, time.UTC)
+
+	Convey("should parse ranges back from now", t, func() {
+		for s, want := range map[string]time.Time{
+			"all": {},
+			"ytd": time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC),
+			"30d": time.Date(2018, 8, 16, 10, 0, 0, 0, time.UTC),
+			"2w":  time.Date(2018, 9, 1, 10, 0, 0, 0, time.UTC),
+			"6m":  time.Date(2018, 3, 15, 10, 0, 0, 0, time.UTC),
+			"1y":  time.Date(2017, 9, 15, 10, 0, 0, 0, time.UTC),
+		} {
+			since, err := parseRange(s, now)
+			So(err, ShouldBeNil)
+			So(since, ShouldResemble, want)
+		}
+
+		for _, s := range []string{"", "d", "0d", "-1m", "3x", "week"} {
+			_, err := parseRange(s, now)
+			So(err, ShouldNotBeNil)
+		}
+	})
+
+	Convey("should find the opening price of the day", t, func() {
+		day := uint64(now.Truncate(24 * time.Hour).Unix())
+		klines := []*huobi.Kline{{ID: day, Open: 6400}, {ID: day - 86400, Open: 6300}}
+
+		open, ok := openAt(klines, now)
+		So(ok, ShouldBeTrue)
+		So(open, ShouldEqual, 6400)
+
+		open, ok = openAt(klines, now.AddDate(0, 0, -1))
+		So(ok, ShouldBeTrue)
+		So(open, ShouldEqual, 6300)
+
+		_, ok = openAt(klines, now.AddDate(0, 0, -2))
+		So(ok, ShouldBeFalse)
+	})
+
+	Convey("should print the summaries with the price precision", t, func() {
+		var b bytes.Buffer
+		So(printReports(&b, []*planReport{
+			{
+				plan:   &config.Plan{Name: "default", Symbol: "btcusdt"},
+				symbol: &huobi.Symbol{QuoteCurrency: "usdt", PricePrecision: 2},
+				results: []*result{
+					{period: period{label: "all"}, summary: &analytics.Summary{
+						Start: now.AddDate(0, -1, 0), End: now, Contributed: 100, Equity: 110.456,
+						Gain: 10.456, ROI: 0.10456, XIRR: 2.5, TWR: 0.1, MaxDrawdown: -0.05,
+						Volatility: 0.6, AverageCost: 6400.123},
+						benchmark: &analytics.Benchmark{Invested: 100, DCA: 110.456, LumpSum: 120, Quote: 100,
+							Advantage: -0.09544}},
+					{period: period{label: "30d"}, summary: &analytics.Summary{}, benchmark: &analytics.Benchmark{}},
+				},
+			},
+			{plan: &config.Plan{Name: "eth", Symbol: "ethusdt"}},
+		}, time.UTC), ShouldBeNil)
+
+		lines := strings.Split(b.String(), "\n")
+		So(lines[0], ShouldEqual, "plan:   default")
+		So(strings.Fields(lines[4]), ShouldResemble, []string{"all", "2018-08-15", "2018-09-15",
+			"100.00", "110.46", "+10.46", "+10.46%", "+250.00%", "+10.00%", "-5.00%", "60.00%", "6400.12"})
+		So(strings.Fields(lines[5])[1], ShouldEqual, "-")
+		So(strings.Fields(lines[7]), ShouldResemble, []string{"RANGE", "INVESTED", "DCA", "LUMP", "SUM",
+			"HOLD", "USDT", "DCA", "VS", "LUMP", "SUM"})
+		So(strings.Fields(lines[8]), ShouldResemble, []string{"all", "100.00", "110.46", "120.00", "100.00", "-9.54%"})
+		So(b.String(), ShouldEndWith, "plan:   eth\nsymbol: ethusdt\nno orders\n\n")
+	})
+
+	Convey("should write a self-contained html page with charts", t, func() {
+		points, flows := analytics.Build(orders(now), "btc", []analytics.Price{
+			{Time: now.AddDate(0, 0, -3), Price: 100},
+			{Time: now.AddDate(0, 0, -1), Price: 50},
+			{Time: now, Price: 80},
+		})
+		r := &planReport{
+			plan:   &config.Plan{Name: "default", Symbol: "btcusdt"},
+			symbol: &huobi.Symbol{QuoteCurrency: "usdt", PricePrecision: 2},
+			orders: orders(now),
+			results: []*result{{period: period{label: "all"}, points: points,
+				summary: analytics.Analyze(points, flows), benchmark: analytics.Compare(points, flows, 100, 80)}},
+		}
+
+		var b bytes.Buffer
+		So(writeHTML(&b, []*planReport{r, {plan: &config.Plan{Name: "<eth>", Symbol: "ethusdt"}}}, time.UTC, now), ShouldBeNil)
+		page := b.String()
+		So(strings.Count(page, "<svg "), ShouldEqual, 3)
+		So(strings.Count(page, "<circle "), ShouldEqual, 2)
+		So(page, ShouldContainSubstring, "2018-09-14 10:00:00 buy 1 at 50.00")
+		So(page, ShouldContainSubstring, "<td>&#43;26.67%</td>")
+		So(page, ShouldContainSubstring, "&lt;eth&gt;")
+		So(strings.Count(page, "http"), ShouldEqual, 3)
+		So(strings.Count(page, "http://www.w3.org/2000/svg"), ShouldEqual, 3)
+	})
+}
+
+// orders 在 now 之前 3 天及 1 天各买入 1 个，单价 100 及 50
+func orders(now time.Time) []*db.Order {
+	return []*db.Order{
+		{ID: 2, Type: "buy-market", Price: 50, BaseAmount: 1, QuoteAmount: 50, Created: uint64(now.AddDate(0, 0, -1).Unix())},
+		{ID: 1, Type: "buy-market", Price: 100, BaseAmount: 1, QuoteAmount: 100, Created: uint64(now.AddDate(0, 0, -3).Unix())},
+	}
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/modood/aip/analytics"
+	"github.com/modood/aip/util"
+
+	"github.com/pkg/errors"
+)
+
+// 图表尺寸
+const (
+	chartWidth  = 800
+	chartHeight = 260
+	chartLeft   = 72   // 左侧留给纵轴刻度
+	chartRight  = 16   // 右侧留白
+	chartTop    = 28   // 顶部留给图例
+	chartBottom = 24   // 底部留给横轴刻度
+	chartPoints = 1000 // 每条折线最多的点数，超过时抽样
+)
+
+// line 折线，数值与图表的时间一一对应，NaN 表示没有值
+type line struct {
+	name   string
+	color  string
+	values []float64
+}
+
+// marker 标记点，如买入
+type marker struct {
+	at    time.Time
+	value float64
+	title string
+}
+
+// chart 以时间为横轴的折线图
+type chart struct {
+	Title   string
+	times   []time.Time
+	lines   []line
+	markers []marker
+	color   string               // 标记点颜色
+	format  func(float64) string // 纵轴刻度格式
+}
+
+// SVG 渲染为内联的 SVG
+func (c *chart) SVG() template.HTML {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" font-family="sans-serif" font-size="11">`,
+		chartWidth, chartHeight, chartWidth, chartHeight)
+
+	if len(c.times) == 0 {
+		b.WriteString(`</svg>`)
+		return template.HTML(b.String())
+	}
+
+	// 坐标范围
+	t0, t1 := c.times[0], c.times[len(c.times)-1]
+	if !t1.After(t0) {
+		t1 = t0.Add(time.Second)
+	}
+	lo, hi := math.Inf(1), math.Inf(-1)
+	extend := func(v float64) {
+		if !math.IsNaN(v) {
+			lo, hi = math.Min(lo, v), math.Max(hi, v)
+		}
+	}
+	for _, l := range c.lines {
+		for _, v := range l.values {
+			extend(v)
+		}
+	}
+	for _, m := range c.markers {
+		extend(m.value)
+	}
+	if math.IsInf(lo, 1) {
+		lo, hi = 0, 1
+	}
+	if hi-lo < 1e-12 {
+		pad := math.Max(math.Abs(hi)*0.05, 1)
+		lo, hi = lo-pad, hi+pad
+	}
+
+	left, right := float64(chartLeft), float64(chartWidth-chartRight)
+	top, bottom := float64(chartTop), float64(chartHeight-chartBottom)
+	x := func(t time.Time) float64 {
+		return left + float64(t.Sub(t0))/float64(t1.Sub(t0))*(right-left)
+	}
+	y := func(v float64) float64 {
+		return bottom - (v-lo)/(hi-lo)*(bottom-top)
+	}
+
+	// 纵轴网格及刻度
+	for i := 0; i <= 4; i++ {
+		v := lo + (hi-lo)*float64(i)/4
+		fmt.Fprintf(&b, `<line x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f" stroke="#e5e5e5"/>`, left, y(v), right, y(v))
+		fmt.Fprintf(&b, `<text x="%.1f" y="%.1f" text-anchor="end" fill="#666">%s</text>`,
+			left-6, y(v)+4, template.HTMLEscapeString(c.format(v)))
+	}
+
+	// 横轴刻度：开始、中间及结束日期
+	for i, anchor := range []string{"start", "middle", "end"} {
+		t := t0.Add(t1.Sub(t0) * time.Duration(i) / 2)
+		fmt.Fprintf(&b, `<text x="%.1f" y="%d" text-anchor="%s" fill="#666">%s</text>`,
+			x(t), chartHeight-6, anchor, t.Format(dateLayout))
+	}
+
+	// 折线，点数过多时抽样，保留最后一个点
+	step := len(c.times)/chartPoints + 1
+	for i, l := range c.lines {
+		var path bytes.Buffer
+		move := true
+		for j := 0; j < len(l.values); j++ {
+			if j%step != 0 && j != len(l.values)-1 {
+				continue
+			}
+			v := l.values[j]
+			if math.IsNaN(v) {
+				move = true
+				continue
+			}
+			cmd := "L"
+			if move {
+				cmd, move = "M", false
+			}
+			fmt.Fprintf(&path, "%s%.1f %.1f ", cmd, x(c.times[j]), y(v))
+		}
+		fmt.Fprintf(&b, `<path d="%s" fill="none" stroke="%s" stroke-width="1.5"/>`, path.String(), l.color)
+
+		// 图例
+		lx := left + float64(i)*160
+		fmt.Fprintf(&b, `<rect x="%.1f" y="8" width="10" height="10" fill="%s"/>`, lx, l.color)
+		fmt.Fprintf(&b, `<text x="%.1f" y="17">%s</text>`, lx+14, template.HTMLEscapeString(l.name))
+	}
+
+	for _, m := range c.markers {
+		fmt.Fprintf(&b, `<circle cx="%.1f" cy="%.1f" r="3" fill="%s"><title>%s</title></circle>`,
+			x(m.at), y(m.value), c.color, template.HTMLEscapeString(m.title))
+	}
+
+	b.WriteString(`</svg>`)
+	return template.HTML(b.String())
+}
+
+// charts 返回计划第一个时间范围的图表：净值与投入、价格与买入及平均成本、回撤
+func (r *planReport) charts(loc *time.Location) []*chart {
+	if len(r.results) == 0 || len(r.results[0].points) == 0 {
+		return nil
+	}
+
+	var (
+		points   = r.results[0].points
+		prec     = r.symbol.PricePrecision
+		times    = make([]time.Time, len(points))
+		equity   = make([]float64, len(points))
+		invested = make([]float64, len(points))
+		price    = make([]float64, len(points))
+		cost     = make([]float64, len(points))
+		drawdown = analytics.Drawdowns(points)
+		amount   = func(v float64) string { return strconv.FormatFloat(v, 'f', prec, 64) }
+	)
+	for i, p := range points {
+		times[i] = p.Time.In(loc)
+		equity[i] = p.Equity
+		invested[i] = p.Investment
+		price[i] = p.Price
+		cost[i] = math.NaN()
+		if p.Position > 0 {
+			cost[i] = p.Investment / p.Position
+		}
+		drawdown[i] *= 100
+	}
+
+	// 买单按时间倒序，只标记图表时间范围内的
+	var buys []marker
+	start, end := times[0], times[len(times)-1]
+	for i := len(r.orders) - 1; i >= 0; i-- {
+		o := r.orders[i]
+		at := time.Unix(int64(o.Created), 0).In(loc)
+		if o.BaseAmount == 0 || at.Before(start) || at.After(end) ||
+			(o.Type != "buy-market" && o.Type != "buy-limit") {
+			continue
+		}
+		buys = append(buys, marker{at: at, value: o.Price, title: fmt.Sprintf("%s buy %v at %s",
+			at.Format("2006-01-02 15:04:05"), o.BaseAmount, amount(o.Price))})
+	}
+
+	return []*chart{
+		{
+			Title: "Equity and investment",
+			times: times,
+			lines: []line{
+				{name: "equity", color: "#2b6cb0", values: equity},
+				{name: "investment", color: "#a0aec0", values: invested},
+			},
+			format: amount,
+		},
+		{
+			Title: "Price, buys and average cost",
+			times: times,
+			lines: []line{
+				{name: "price", color: "#2f855a", values: price},
+				{name: "average cost", color: "#dd6b20", values: cost},
+			},
+			markers: buys,
+			color:   "#dd6b20",
+			format:  amount,
+		},
+		{
+			Title:  "Drawdown",
+			times:  times,
+			lines:  []line{{name: "drawdown", color: "#c53030", values: drawdown}},
+			format: func(v float64) string { return fmt.Sprintf("%.1f%%", v) },
+		},
+	}
+}
+
+// htmlPlan 计划在 HTML 报告中的内容
+type htmlPlan struct {
+	Name   string
+	Symbol string
+	Range  string
+	Tables [][][]string
+	Charts []*chart
+}
+
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>aip report {{.Generated}}</title>
+<style>
+body { font-family: sans-serif; color: #1a202c; margin: 24px; }
+table { border-collapse: collapse; margin: 12px 0; font-size: 13px; }
+th, td { border-bottom: 1px solid #e2e8f0; padding: 4px 10px; text-align: right; white-space: nowrap; }
+th:first-child, td:first-child { text-align: left; }
+h3 { font-size: 14px; margin: 16px 0 4px; }
+.muted { color: #718096; }
+</style>
+</head>
+<body>
+<h1>aip report</h1>
+<p class="muted">Generated at {{.Generated}}</p>
+{{range .Plans}}{{$plan := .}}
+<h2>{{.Name}} <span class="muted">{{.Symbol}}</span></h2>
+{{if not .Tables}}<p>No orders.</p>{{end}}
+{{range .Tables}}
+<table>
+{{range $i, $row := .}}<tr>{{range $row}}{{if eq $i 0}}<th>{{.}}</th>{{else}}<td>{{.}}</td>{{end}}{{end}}</tr>
+{{end}}</table>
+{{end}}
+{{range .Charts}}
+<h3>{{.Title}} <span class="muted">({{$plan.Range}})</span></h3>
+{{.SVG}}
+{{end}}
+{{end}}
+</body>
+</html>
+`))
+
+// writeHTML 输出自包含的 HTML 报告，不引用外部资源，图表为内联的 SVG
+func writeHTML(out io.Writer, reports []*planReport, loc *time.Location, now time.Time) error {
+	data := struct {
+		Generated string
+		Plans     []*htmlPlan
+	}{Generated: now.In(loc).Format("2006-01-02 15:04:05")}
+
+	for _, r := range reports {
+		p := &htmlPlan{Name: r.plan.Name, Symbol: r.plan.Symbol}
+		if len(r.results) > 0 {
+			p.Tables = r.tables(loc)
+			p.Charts = r.charts(loc)
+			p.Range = r.results[0].period.label
+		}
+		data.Plans = append(data.Plans, p)
+	}
+
+	if err := reportTemplate.Execute(out, data); err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+
+	return nil
+}
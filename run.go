@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"github.com/modood/aip/util"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var runCmd = &cobra.Command{
+	Use:   "run",
+	Short: "run the plans as a daemon",
+	Args:  cobra.NoArgs,
+	RunE:  run,
+}
+
+func run(cmd *cobra.Command, args []string) error {
+	e, err := setupTrade()
+	if err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+	cfg := e.cfg
+
+	// 收到 SIGINT、SIGTERM 时退出，SIGHUP 时重新加载配置。启动时即开始接收，
+	// 启动补投期间收到的信号在补投完成后处理，不会中断正在进行的交易
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+
+	// 执行定投计划
+	d := newDaemon(e.client, e.store, e.loc)
+	abort := func(err error) error {
+		if err := d.shutdown(cfg.ShutdownTimeout); err != nil {
+			log.Println(err)
+		}
+		if err := e.store.Close(); err != nil {
+			log.Println(err)
+		}
+		return errors.Wrap(err, util.FuncName())
+	}
+	// 单个计划启动失败（如租约由其他实例持有）已输出，不影响其他计划运行
+	if err = d.apply(cfg); err != nil {
+		log.Println(errors.Wrap(err, util.FuncName()))
+	}
+
+	// JSON 接口及控制台
+	var srv *http.Server
+	if cfg.HTTP != "" {
+		if srv, err = serve(d, cfg.HTTP); err != nil {
+			return abort(err)
+		}
+	}
+
+	// 配置文件变更时重新加载，与 SIGHUP 一样在下面的循环中依次处理
+	changes := make(chan string, 1)
+	if file := viper.GetString("config"); file != "" {
+		watcher, err := watch(file, changes)
+		if err != nil {
+			return abort(err)
+		}
+		defer watcher.Close()
+	}
+
+loop:
+	for {
+		select {
+		case name := <-changes:
+			log.Printf("config file %s changed, reloading\n", name)
+			reload(d)
+		case sig := <-signals:
+			if sig == syscall.SIGHUP {
+				log.Println("SIGHUP received, reloading config")
+				reload(d)
+				continue
+			}
+
+			log.Printf("%s received, waiting up to %s for running jobs\n", sig, cfg.ShutdownTimeout)
+			break loop
+		}
+	}
+	signal.Stop(signals)
+
+	if srv != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Println(errors.Wrap(err, util.FuncName()))
+		}
+		cancel()
+	}
+
+	if err = d.shutdown(cfg.ShutdownTimeout); err != nil {
+		log.Println(err)
+	}
+
+	if err := e.store.Close(); err != nil {
+		log.Println(err)
+	}
+
+	if err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+
+	log.Println("aip exited cleanly")
+
+	return nil
+}
+
+// watch 监视配置文件，文件写入或重新创建时发送文件名，未处理的变更只保留一次。
+// 监视所在目录，编辑器以重命名方式保存时也能发现
+func watch(file string, changes chan<- string) (*fsnotify.Watcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, errors.Wrap(err, util.FuncName())
+	}
+
+	file = filepath.Clean(file)
+	if err = watcher.Add(filepath.Dir(file)); err != nil {
+		watcher.Close()
+		return nil, errors.Wrap(err, util.FuncName())
+	}
+
+	go func() {
+		for {
+			select {
+			case ev, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(ev.Name) != file || ev.Op&(fsnotify.Write|fsnotify.Create) == 0 {
+					continue
+				}
+				select {
+				case changes <- ev.Name:
+				default:
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Println(errors.Wrap(err, util.FuncName()))
+			}
+		}
+	}()
+
+	return watcher, nil
+}
+
+// reload 重新加载配置中的定投计划，校验失败时继续使用当前配置。
+// 数据库、时区及 API 参数的变更需要重启后生效。只在 run 的主循环中调用，不会同时重新加载
+func reload(d *daemon) {
+	d.mu.Lock()
+	current := d.cfg
+	d.mu.Unlock()
+
+	cfg, err := loadConfig()
+	if err == nil {
+		err = cfg.Validate()
+	}
+	if err == nil {
+		err = cfg.ValidateTrade()
+	}
+	if err == nil {
+		err = cfg.ValidateMarket(d.client)
+	}
+	if err != nil {
+		log.Println(errors.Wrap(err, "invalid config, keep running the previous one"))
+		return
+	}
+
+	if cfg.DBFile != current.DBFile || cfg.DBURL != current.DBURL || cfg.Timezone != current.Timezone ||
+		cfg.APIHost != current.APIHost || cfg.APIKey != current.APIKey ||
+		cfg.APISecret != current.APISecret || cfg.ShutdownTimeout != current.ShutdownTimeout ||
+		cfg.HTTP != current.HTTP {
+		log.Println("changes of dbfile, timezone, api, shutdown and http address settings take effect after restart")
+	}
+
+	if err = d.apply(cfg); err != nil {
+		log.Println(errors.Wrap(err, util.FuncName()))
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/modood/aip/plan"
+	"github.com/modood/aip/util"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var scheduleCmd = &cobra.Command{
+	Use:   "schedule",
+	Short: "print the next execution times of the plans",
+	Args:  cobra.NoArgs,
+	RunE:  schedule,
+}
+
+func init() {
+	scheduleCmd.Flags().IntP("count", "n", 10, "number of execution times to print")
+	scheduleCmd.Flags().String("plan", "", "only print the plan with this name")
+}
+
+func schedule(cmd *cobra.Command, args []string) error {
+	count, err := cmd.Flags().GetInt("count")
+	if err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+
+	name, err := cmd.Flags().GetString("plan")
+	if err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+
+	loc, err := cfg.Location()
+	if err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+
+	for _, c := range cfg.Plans {
+		if name != "" && c.Name != name {
+			continue
+		}
+
+		p, err := c.NewPeriod()
+		if err != nil {
+			return errors.Wrap(err, util.FuncName())
+		}
+
+		fmt.Printf("plan:     %s\n", c.Name)
+		fmt.Printf("period:   %s\n", c.Period.Type)
+		fmt.Printf("schedule: %s\n", p.Schedule())
+		fmt.Printf("timezone: %s\n", loc)
+		for _, t := range plan.Upcoming(p, time.Now().In(loc), count) {
+			fmt.Println(t.Format("2006-01-02 15:04:05 Mon -0700 MST"))
+		}
+		fmt.Println()
+	}
+
+	return nil
+}
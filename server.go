@@ -0,0 +1,565 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/modood/aip/config"
+	"github.com/modood/aip/db"
+	"github.com/modood/aip/util"
+
+	"github.com/pkg/errors"
+)
+
+// defaultLimit 订单及统计接口默认返回的条数
+const defaultLimit = 100
+
+var (
+	errControlDisabled  = errors.New("control endpoints are disabled, set http-token to enable them")
+	errUnauthorized     = errors.New("missing or wrong bearer token")
+	errUnknownAction    = errors.New("unknown action, available: pause, resume and invest")
+	errInvalidParameter = errors.New("invalid parameter")
+	errNotFound         = errors.New(http.StatusText(http.StatusNotFound))
+	errMethodNotAllowed = errors.New(http.StatusText(http.StatusMethodNotAllowed))
+)
+
+// readHeaderTimeout 读取请求头的超时时间，避免慢速连接长期占用守护进程
+const readHeaderTimeout = 10 * time.Second
+
+// server 守护进程的 JSON 接口及控制台，与守护进程共用数据库及计划。
+//
+//	GET  /                         控制台
+//	GET  /api/plans                计划、状态及下次执行时间
+//	GET  /api/plans/{name}         单个计划
+//	GET  /api/orders               订单，参数 plan, symbol, since, until (yyyy-mm-dd), limit
+//	GET  /api/statistics           统计，参数同订单
+//	GET  /api/errors               最近的任务错误，参数 plan
+//	POST /api/plans/{name}/pause   暂停定时投资，需要令牌
+//	POST /api/plans/{name}/resume  恢复定时投资，需要令牌
+//	POST /api/plans/{name}/invest  立即投资一次，需要令牌
+//	GET  /metrics                  Prometheus 指标
+type server struct {
+	d *daemon
+}
+
+// planView 计划接口的返回值
+type planView struct {
+	Name      string       `json:"name"`
+	Symbol    string       `json:"symbol"`
+	Amount    float64      `json:"amount"`
+	Period    string       `json:"period"`
+	Running   bool         `json:"running"` // 在本实例中调度
+	Paused    bool         `json:"paused"`
+	NextRun   string       `json:"next_run,omitempty"`
+	State     *stateView   `json:"state,omitempty"`
+	LastError *failureView `json:"last_error,omitempty"`
+}
+
+// stateView 计划的持仓及净值
+type stateView struct {
+	Position   float64 `json:"position"`
+	Investment float64 `json:"investment"`
+	Price      float64 `json:"price"`
+	Equity     float64 `json:"equity"`
+	ROI        float64 `json:"roi"`
+	Updated    string  `json:"updated"`
+}
+
+// failureView 任务错误
+type failureView struct {
+	Time  string `json:"time"`
+	Plan  string `json:"plan,omitempty"`
+	Job   string `json:"job"`
+	Error string `json:"error"`
+}
+
+// orderView 订单，键与导出的列名一致
+type orderView struct {
+	ID          uint64  `json:"id"`
+	Time        string  `json:"time"`
+	Finished    string  `json:"finished"`
+	Plan        string  `json:"plan"`
+	Symbol      string  `json:"symbol"`
+	Type        string  `json:"type"`
+	State       string  `json:"state"`
+	Price       float64 `json:"price"`
+	Amount      float64 `json:"amount"`
+	BaseAmount  float64 `json:"base_amount"`
+	QuoteAmount float64 `json:"quote_amount"`
+	Fees        float64 `json:"fees"`
+	FeeCurrency string  `json:"fee_currency"`
+}
+
+// statisticsView 统计，键与导出的列名一致
+type statisticsView struct {
+	Time       string  `json:"time"`
+	Plan       string  `json:"plan"`
+	Symbol     string  `json:"symbol"`
+	Position   float64 `json:"position"`
+	Investment float64 `json:"investment"`
+	Price      float64 `json:"price"`
+	Equity     float64 `json:"equity"`
+}
+
+// serve 在指定地址上启动 JSON 接口及控制台
+func serve(d *daemon, addr string) (*http.Server, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, errors.Wrap(err, util.FuncName())
+	}
+
+	srv := &http.Server{Handler: (&server{d: d}).handler(), ReadHeaderTimeout: readHeaderTimeout}
+	go func() {
+		if err := srv.Serve(l); err != nil && err != http.ErrServerClosed {
+			log.Println(errors.Wrap(err, util.FuncName()))
+		}
+	}()
+	log.Printf("serving the json api, dashboard and metrics on http://%s/\n", l.Addr())
+
+	return srv, nil
+}
+
+// handler 返回路由
+func (s *server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.dashboard)
+	mux.HandleFunc("/api/plans", s.get(s.plans))
+	mux.HandleFunc("/api/plans/", s.plan)
+	mux.HandleFunc("/api/orders", s.get(s.orders))
+	mux.HandleFunc("/api/statistics", s.get(s.statistics))
+	mux.HandleFunc("/api/errors", s.get(s.failures))
+	mux.HandleFunc("/metrics", s.metrics)
+	return mux
+}
+
+// get 只允许 GET 请求，返回值以 JSON 输出
+func (s *server) get(fn func(r *http.Request) (interface{}, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			reply(w, nil, errMethodNotAllowed)
+			return
+		}
+
+		v, err := fn(r)
+		reply(w, v, err)
+	}
+}
+
+// reply 以 JSON 输出返回值或错误，错误为 {"error": "..."}，状态码按错误类型确定
+func reply(w http.ResponseWriter, v interface{}, err error) {
+	code := http.StatusOK
+	if err != nil {
+		switch errors.Cause(err) {
+		case errInvalidParameter:
+			code = http.StatusBadRequest
+		case errUnauthorized:
+			code = http.StatusUnauthorized
+		case errControlDisabled:
+			code = http.StatusForbidden
+		case errPlanNotFound, errUnknownAction, errNotFound:
+			code = http.StatusNotFound
+		case errMethodNotAllowed:
+			code = http.StatusMethodNotAllowed
+		case errPlanNotRunning, errPlanRunning:
+			code = http.StatusConflict
+		default:
+			code = http.StatusInternalServerError
+		}
+		v = map[string]string{"error": err.Error()}
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println(errors.Wrap(err, util.FuncName()))
+	}
+}
+
+// config 返回当前应用的配置
+func (s *server) config() *config.Config {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	return s.d.cfg
+}
+
+// view 返回计划的配置、调度及状态
+func (s *server) view(c *config.Plan, now time.Time) *planView {
+	v := &planView{
+		Name:   c.Name,
+		Symbol: c.Symbol,
+		Amount: c.Amount,
+		Period: c.Period.Type,
+	}
+
+	s.d.mu.Lock()
+	w, ok := s.d.workers[c.Name]
+	running := ok && !w.standby // 待命的计划由其他实例运行
+	v.Paused = s.d.paused[c.Name]
+	s.d.mu.Unlock()
+
+	if running {
+		v.Running = true
+		if next := w.plan.Period().Next(now); !next.IsZero() {
+			v.NextRun = next.Format(time.RFC3339)
+		}
+
+		st := w.plan.State()
+		v.State = &stateView{
+			Position:   st.Position,
+			Investment: st.Investment,
+			Price:      st.Price,
+			Equity:     st.Equity,
+			Updated:    s.time(st.Updated),
+		}
+		if st.Investment > 0 {
+			v.State.ROI = (st.Equity - st.Investment) / st.Investment
+		}
+	}
+
+	if f := s.d.lastFailures(c.Name); len(f) > 0 {
+		v.LastError = s.failure(f[0])
+	}
+
+	return v
+}
+
+// plans 返回全部计划
+func (s *server) plans(r *http.Request) (interface{}, error) {
+	cfg := s.config()
+	now := time.Now().In(s.d.loc)
+
+	plans := make([]*planView, 0, len(cfg.Plans))
+	for _, c := range cfg.Plans {
+		plans = append(plans, s.view(c, now))
+	}
+	return plans, nil
+}
+
+// plan 处理 /api/plans/{name} 及 /api/plans/{name}/{action}
+func (s *server) plan(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/plans/"), "/")
+	name := parts[0]
+
+	if len(parts) == 1 {
+		s.get(func(r *http.Request) (interface{}, error) {
+			c := s.config().Plan(name)
+			if c == nil {
+				return nil, errors.Wrap(errPlanNotFound, name)
+			}
+			return s.view(c, time.Now().In(s.d.loc)), nil
+		})(w, r)
+		return
+	}
+
+	if len(parts) != 2 {
+		reply(w, nil, errNotFound)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		reply(w, nil, errMethodNotAllowed)
+		return
+	}
+	if err := s.authorize(r); err != nil {
+		reply(w, nil, err)
+		return
+	}
+
+	var err error
+	switch parts[1] {
+	case "pause":
+		err = s.d.pause(name, true)
+	case "resume":
+		err = s.d.pause(name, false)
+	case "invest":
+		err = s.d.investNow(name)
+	default:
+		err = errors.Wrap(errUnknownAction, parts[1])
+	}
+	if err != nil {
+		reply(w, nil, err)
+		return
+	}
+
+	c := s.config().Plan(name)
+	if c == nil {
+		reply(w, nil, errors.Wrap(errPlanNotFound, name))
+		return
+	}
+	reply(w, s.view(c, time.Now().In(s.d.loc)), nil)
+}
+
+// authorize 校验 Authorization: Bearer 令牌，未配置令牌时禁用控制接口
+func (s *server) authorize(r *http.Request) error {
+	token := s.config().HTTPToken
+	if token == "" {
+		return errControlDisabled
+	}
+
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return errUnauthorized
+	}
+	got := strings.TrimPrefix(header, "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+		return errUnauthorized
+	}
+
+	return nil
+}
+
+// filter 解析订单及统计的查询参数，日期按配置的时区解析
+func (s *server) filter(r *http.Request) (*db.Filter, error) {
+	q := r.URL.Query()
+	f := &db.Filter{Plan: q.Get("plan"), Symbol: q.Get("symbol"), Limit: defaultLimit}
+
+	for name, v := range map[string]*uint64{"since": &f.Since, "until": &f.Until} {
+		if q.Get(name) == "" {
+			continue
+		}
+		t, err := time.ParseInLocation(dateLayout, q.Get(name), s.d.loc)
+		if err != nil {
+			return nil, errors.Wrapf(errInvalidParameter, "%s must be yyyy-mm-dd, got %q", name, q.Get(name))
+		}
+		*v = uint64(t.Unix())
+	}
+
+	if l := q.Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 0 {
+			return nil, errors.Wrapf(errInvalidParameter, "limit must be a number not less than 0, 0 for all, got %q", l)
+		}
+		f.Limit = n
+	}
+
+	return f, nil
+}
+
+// orders 返回订单，按时间倒序
+func (s *server) orders(r *http.Request) (interface{}, error) {
+	f, err := s.filter(r)
+	if err != nil {
+		return nil, errors.Wrap(err, util.FuncName())
+	}
+
+	orders, err := s.d.store.Orders(f)
+	if err != nil {
+		return nil, errors.Wrap(err, util.FuncName())
+	}
+
+	views := make([]*orderView, 0, len(orders))
+	for _, o := range orders {
+		views = append(views, &orderView{
+			ID:          o.ID,
+			Time:        s.time(o.Created),
+			Finished:    s.time(o.Finished),
+			Plan:        o.Plan,
+			Symbol:      o.Symbol,
+			Type:        o.Type,
+			State:       o.State,
+			Price:       o.Price,
+			Amount:      o.Amount,
+			BaseAmount:  o.BaseAmount,
+			QuoteAmount: o.QuoteAmount,
+			Fees:        o.Fees,
+			FeeCurrency: o.FeeCurrency,
+		})
+	}
+	return views, nil
+}
+
+// statistics 返回统计，按时间倒序
+func (s *server) statistics(r *http.Request) (interface{}, error) {
+	f, err := s.filter(r)
+	if err != nil {
+		return nil, errors.Wrap(err, util.FuncName())
+	}
+
+	stats, err := s.d.store.ListStatistics(f)
+	if err != nil {
+		return nil, errors.Wrap(err, util.FuncName())
+	}
+
+	views := make([]*statisticsView, 0, len(stats))
+	for _, st := range stats {
+		views = append(views, &statisticsView{
+			Time:       s.time(st.Created),
+			Plan:       st.Plan,
+			Symbol:     st.Symbol,
+			Position:   st.Position,
+			Investment: st.Investment,
+			Price:      st.Price,
+			Equity:     st.Equity,
+		})
+	}
+	return views, nil
+}
+
+// failures 返回最近的任务错误，最新的在前
+func (s *server) failures(r *http.Request) (interface{}, error) {
+	failures := s.d.lastFailures(r.URL.Query().Get("plan"))
+	views := make([]*failureView, 0, len(failures))
+	for _, f := range failures {
+		views = append(views, s.failure(f))
+	}
+	return views, nil
+}
+
+// failure 转换任务错误
+func (s *server) failure(f *failure) *failureView {
+	return &failureView{
+		Time:  f.Time.In(s.d.loc).Format(time.RFC3339),
+		Plan:  f.Plan,
+		Job:   f.Job,
+		Error: f.Error,
+	}
+}
+
+// time 格式化 unix 时间，0 表示空值
+func (s *server) time(t uint64) string {
+	if t == 0 {
+		return ""
+	}
+	return time.Unix(int64(t), 0).In(s.d.loc).Format(time.RFC3339)
+}
+
+// dashboard 控制台页面，数据由页面中的脚本从 JSON 接口获取
+func (s *server) dashboard(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if _, err := io.WriteString(w, dashboardPage); err != nil {
+		log.Println(errors.Wrap(err, util.FuncName()))
+	}
+}
+
+// dashboardPage 控制台页面，不引用外部资源
+const dashboardPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>aip</title>
+<style>
+body { font-family: sans-serif; color: #1a202c; margin: 24px; }
+table { border-collapse: collapse; margin: 8px 0 24px; font-size: 13px; }
+th, td { border-bottom: 1px solid #e2e8f0; padding: 4px 10px; text-align: left; white-space: nowrap; }
+button { margin-right: 4px; }
+.muted { color: #718096; }
+.error { color: #c53030; }
+</style>
+</head>
+<body>
+<h1>aip</h1>
+<p class="muted">Refreshes every 30 seconds. <label>Token <input id="token" type="password" size="24"></label></p>
+<h2>Plans</h2>
+<table id="plans"></table>
+<h2>Recent orders</h2>
+<table id="orders"></table>
+<h2>Recent errors</h2>
+<table id="errors"></table>
+<script>
+var token = document.getElementById("token");
+token.value = sessionStorage.getItem("aip-token") || "";
+token.onchange = function () { sessionStorage.setItem("aip-token", token.value); };
+
+function cell(row, text, tag, cls) {
+  var c = document.createElement(tag || "td");
+  c.textContent = text === undefined || text === null ? "" : text;
+  if (cls) c.className = cls;
+  row.appendChild(c);
+  return c;
+}
+
+function fill(id, columns, rows, extra) {
+  var table = document.getElementById(id);
+  table.innerHTML = "";
+  var head = table.insertRow();
+  columns.forEach(function (c) { cell(head, c[0], "th"); });
+  if (extra) cell(head, "", "th");
+  rows.forEach(function (r) {
+    var row = table.insertRow();
+    columns.forEach(function (c) { cell(row, c[1](r)); });
+    if (extra) extra(cell(row, ""), r);
+  });
+}
+
+function percent(v) { return (v >= 0 ? "+" : "") + (v * 100).toFixed(2) + "%"; }
+
+function action(name, act) {
+  fetch("api/plans/" + encodeURIComponent(name) + "/" + act, {
+    method: "POST",
+    headers: { "Authorization": "Bearer " + token.value }
+  }).then(function (res) {
+    return res.json().then(function (body) {
+      if (!res.ok) alert(body.error);
+      load();
+    });
+  });
+}
+
+function load() {
+  fetch("api/plans").then(function (r) { return r.json(); }).then(function (plans) {
+    fill("plans", [
+      ["plan", function (p) { return p.name; }],
+      ["symbol", function (p) { return p.symbol; }],
+      ["amount", function (p) { return p.amount; }],
+      ["period", function (p) { return p.period; }],
+      ["status", function (p) { return !p.running ? "not running" : p.paused ? "paused" : "running"; }],
+      ["next run", function (p) { return p.paused ? "" : p.next_run; }],
+      ["position", function (p) { return p.state && p.state.position; }],
+      ["investment", function (p) { return p.state && p.state.investment; }],
+      ["price", function (p) { return p.state && p.state.price; }],
+      ["equity", function (p) { return p.state && p.state.equity; }],
+      ["roi", function (p) { return p.state && percent(p.state.roi); }],
+      ["last error", function (p) { return p.last_error && p.last_error.time + " " + p.last_error.error; }]
+    ], plans, function (c, p) {
+      [p.paused ? "resume" : "pause", "invest"].forEach(function (act) {
+        var b = document.createElement("button");
+        b.textContent = act;
+        b.onclick = function () {
+          if (act !== "invest" || confirm("Invest " + p.amount + " in " + p.symbol + " now?")) action(p.name, act);
+        };
+        c.appendChild(b);
+      });
+    });
+  });
+  fetch("api/orders?limit=20").then(function (r) { return r.json(); }).then(function (orders) {
+    fill("orders", [
+      ["time", function (o) { return o.time; }],
+      ["plan", function (o) { return o.plan; }],
+      ["symbol", function (o) { return o.symbol; }],
+      ["type", function (o) { return o.type; }],
+      ["state", function (o) { return o.state; }],
+      ["price", function (o) { return o.price; }],
+      ["amount", function (o) { return o.base_amount; }],
+      ["cost", function (o) { return o.quote_amount; }],
+      ["id", function (o) { return o.id; }]
+    ], orders);
+  });
+  fetch("api/errors").then(function (r) { return r.json(); }).then(function (errors) {
+    fill("errors", [
+      ["time", function (e) { return e.time; }],
+      ["plan", function (e) { return e.plan; }],
+      ["job", function (e) { return e.job; }],
+      ["error", function (e) { return e.error; }]
+    ], errors.slice(0, 20));
+  });
+}
+
+load();
+setInterval(load, 30000);
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/modood/aip/config"
+	"github.com/modood/aip/db"
+
+	"github.com/pkg/errors"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestServer(t *testing.T) {
+	store, err := db.Init(db.DriverSQLite, "/tmp/aip.sqlite3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	name := "server" + time.Now().Format("150405.000")
+	pid, err := store.AddPlan(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = store.AddOrder(&db.Order{ID: uint64(time.Now().UnixNano()), PlanID: pid, Plan: name, Symbol: "btcusdt",
+		Type: "buy-market", State: "filled", Price: 6400, BaseAmount: 0.0015, QuoteAmount: 10,
+		Created: uint64(time.Now().Unix())}); err != nil {
+		t.Fatal(err)
+	}
+
+	d := newDaemon(nil, store, time.UTC)
+	d.cfg = &config.Config{Plans: []*config.Plan{{Name: name, Symbol: "btcusdt", Amount: 10,
+		Period: config.Period{Type: "daily"}}}}
+	ts := httptest.NewServer((&server{d: d}).handler())
+	defer ts.Close()
+
+	request := func(method, path, token string, v interface{}) int {
+		req, err := http.NewRequest(method, ts.URL+path, nil)
+		So(err, ShouldBeNil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		res, err := http.DefaultClient.Do(req)
+		So(err, ShouldBeNil)
+		defer res.Body.Close()
+		So(res.Header.Get("Content-Type"), ShouldStartWith, "application/json")
+		So(json.NewDecoder(res.Body).Decode(v), ShouldBeNil)
+		return res.StatusCode
+	}
+
+	Convey("should list the configured plans", t, func() {
+		var plans []*planView
+		So(request("GET", "/api/plans", "", &plans), ShouldEqual, http.StatusOK)
+		So(len(plans), ShouldEqual, 1)
+		So(plans[0].Name, ShouldEqual, name)
+		So(plans[0].Running, ShouldBeFalse)
+
+		var e map[string]string
+		So(request("GET", "/api/plans/nope", "", &e), ShouldEqual, http.StatusNotFound)
+		So(e["error"], ShouldContainSubstring, "plan not found")
+	})
+
+	Convey("should list orders with filters", t, func() {
+		var orders []*orderView
+		So(request("GET", "/api/orders?plan="+name+"&limit=0", "", &orders), ShouldEqual, http.StatusOK)
+		So(len(orders), ShouldEqual, 1)
+		So(orders[0].QuoteAmount, ShouldEqual, 10)
+
+		var e map[string]string
+		So(request("GET", "/api/orders?since=yesterday", "", &e), ShouldEqual, http.StatusBadRequest)
+		So(request("GET", "/api/statistics?limit=-1", "", &e), ShouldEqual, http.StatusBadRequest)
+	})
+
+	Convey("should require the token to pause and resume", t, func() {
+		var e map[string]string
+		So(request("POST", "/api/plans/"+name+"/pause", "", &e), ShouldEqual, http.StatusForbidden)
+
+		d.cfg.HTTPToken = "secret"
+		So(request("POST", "/api/plans/"+name+"/pause", "", &e), ShouldEqual, http.StatusUnauthorized)
+		So(request("POST", "/api/plans/"+name+"/pause", "wrong", &e), ShouldEqual, http.StatusUnauthorized)
+
+		// 没有 Bearer 前缀的令牌不被接受
+		req, err := http.NewRequest("POST", ts.URL+"/api/plans/"+name+"/pause", nil)
+		So(err, ShouldBeNil)
+		req.Header.Set("Authorization", "secret")
+		res, err := http.DefaultClient.Do(req)
+		So(err, ShouldBeNil)
+		res.Body.Close()
+		So(res.StatusCode, ShouldEqual, http.StatusUnauthorized)
+		So(request("GET", "/api/plans/"+name+"/pause", "secret", &e), ShouldEqual, http.StatusMethodNotAllowed)
+		So(request("POST", "/api/plans/"+name+"/sell", "secret", &e), ShouldEqual, http.StatusNotFound)
+
+		var p planView
+		So(request("POST", "/api/plans/"+name+"/pause", "secret", &p), ShouldEqual, http.StatusOK)
+		So(p.Paused, ShouldBeTrue)
+		So(d.isPaused(name), ShouldBeTrue)
+
+		So(request("POST", "/api/plans/"+name+"/resume", "secret", &p), ShouldEqual, http.StatusOK)
+		So(p.Paused, ShouldBeFalse)
+
+		So(request("POST", "/api/plans/"+name+"/invest", "secret", &e), ShouldEqual, http.StatusConflict)
+		So(e["error"], ShouldContainSubstring, "not running")
+	})
+
+	Convey("should report the last errors", t, func() {
+		d.fail(name, "monitor", errors.New("exchange unreachable"))
+
+		var failures []*failureView
+		So(request("GET", "/api/errors?plan="+name, "", &failures), ShouldEqual, http.StatusOK)
+		So(len(failures), ShouldEqual, 1)
+		So(failures[0].Job, ShouldEqual, "monitor")
+
+		var p planView
+		So(request("GET", "/api/plans/"+name, "", &p), ShouldEqual, http.StatusOK)
+		So(p.LastError.Error, ShouldEqual, "exchange unreachable")
+	})
+
+	Convey("should serve the metrics in text format", t, func() {
+		res, err := http.Get(ts.URL + "/metrics")
+		So(err, ShouldBeNil)
+		defer res.Body.Close()
+		So(res.StatusCode, ShouldEqual, http.StatusOK)
+		So(res.Header.Get("Content-Type"), ShouldStartWith, "text/plain; version=0.0.4")
+
+		b, err := ioutil.ReadAll(res.Body)
+		So(err, ShouldBeNil)
+		So(string(b), ShouldNotContainSubstring, name) // 计划未运行
+	})
+
+	Convey("should serve the dashboard", t, func() {
+		res, err := http.Get(ts.URL + "/")
+		So(err, ShouldBeNil)
+		defer res.Body.Close()
+		So(res.StatusCode, ShouldEqual, http.StatusOK)
+		So(res.Header.Get("Content-Type"), ShouldStartWith, "text/html")
+		So(strings.Contains(dashboardPage, "api/plans"), ShouldBeTrue)
+	})
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/modood/aip/util"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "print position, investment, price, equity and ROI of the plans",
+	Args:  cobra.NoArgs,
+	RunE:  status,
+}
+
+func init() {
+	statusCmd.Flags().String("plan", "", "only print the plan with this name")
+}
+
+func status(cmd *cobra.Command, args []string) error {
+	name, err := cmd.Flags().GetString("plan")
+	if err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+
+	e, err := setup()
+	if err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+
+	plans, err := e.plans(name)
+	if err != nil {
+		return errors.Wrap(err, util.FuncName())
+	}
+
+	for _, c := range plans {
+		position, investment, err := e.store.OrderSummary(c.Name, c.Symbol)
+		if err != nil {
+			return errors.Wrap(err, util.FuncName())
+		}
+
+		price, err := e.client.SymbolPrice(c.Symbol)
+		if err != nil {
+			return errors.Wrap(err, util.FuncName())
+		}
+
+		equity := price * position
+
+		fmt.Printf("plan:       %s\n", c.Name)
+		fmt.Printf("symbol:     %s\n", c.Symbol)
+		fmt.Printf("position:   %v\n", position)
+		fmt.Printf("investment: %v\n", investment)
+		fmt.Printf("price:      %v\n", price)
+		fmt.Printf("equity:     %v\n", equity)
+		if investment > 0 {
+			fmt.Printf("roi:        %+.2f%%\n", (equity-investment)/investment*100)
+		} else {
+			fmt.Printf("roi:        -\n")
+		}
+		fmt.Println()
+	}
+
+	return nil
+}